@@ -0,0 +1,102 @@
+package backlinker
+
+// matcher finds all of the occurrences of a set of patterns in a text in one pass, no
+// matter how many patterns there are, using the Aho-Corasick algorithm. It works on
+// runes, so that the positions it finds can be used with unicode text.
+type matcher struct {
+	// next holds the transitions out of each state of the trie.
+	next []map[rune]int
+	// fail is the state to fall back to when there is no transition for a rune: the
+	// longest suffix of the current state that is also in the trie.
+	fail []int
+	// output lists the patterns that end at each state, including through fail.
+	output [][]int
+	// lengths has the length of each pattern, in runes.
+	lengths []int
+}
+
+// match is an occurrence of the pattern with the index Pattern, at the runes
+// text[Start:End].
+type match struct {
+	Pattern int
+	Start   int
+	End     int
+}
+
+// newMatcher builds the matcher for the patterns. Empty patterns are never matched.
+func newMatcher(patterns []string) *matcher {
+	m := &matcher{
+		next:    []map[rune]int{{}},
+		fail:    []int{0},
+		output:  [][]int{nil},
+		lengths: make([]int, len(patterns)),
+	}
+	for i, pattern := range patterns {
+		state := 0
+		for _, r := range pattern {
+			nextState, exists := m.next[state][r]
+			if !exists {
+				nextState = len(m.next)
+				m.next = append(m.next, map[rune]int{})
+				m.fail = append(m.fail, 0)
+				m.output = append(m.output, nil)
+				m.next[state][r] = nextState
+			}
+			state = nextState
+			m.lengths[i]++
+		}
+		if state != 0 {
+			m.output[state] = append(m.output[state], i)
+		}
+	}
+
+	// The fail links are found breadth first, so that the states closer to the root
+	// (which the fail links point to) are always done first.
+	queue := make([]int, 0, len(m.next))
+	for _, state := range m.next[0] {
+		queue = append(queue, state)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for r, nextState := range m.next[state] {
+			fallback := m.fail[state]
+			for fallback != 0 {
+				if _, exists := m.next[fallback][r]; exists {
+					break
+				}
+				fallback = m.fail[fallback]
+			}
+			if target, exists := m.next[fallback][r]; exists && target != nextState {
+				m.fail[nextState] = target
+			}
+			m.output[nextState] = append(m.output[nextState], m.output[m.fail[nextState]]...)
+			queue = append(queue, nextState)
+		}
+	}
+	return m
+}
+
+// find returns every occurrence of the patterns in the text, including ones that
+// overlap, in the order in which they end.
+func (m *matcher) find(text []rune) []match {
+	var matches []match
+	state := 0
+	for i, r := range text {
+		for state != 0 {
+			if _, exists := m.next[state][r]; exists {
+				break
+			}
+			state = m.fail[state]
+		}
+		state = m.next[state][r]
+		for _, pattern := range m.output[state] {
+			matches = append(matches, match{
+				Pattern: pattern,
+				Start:   i + 1 - m.lengths[pattern],
+				End:     i + 1,
+			})
+		}
+	}
+	return matches
+}
@@ -0,0 +1,21 @@
+package backlinker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	require := require.New(t)
+	m := newMatcher([]string{"he", "she", "his", "hers", "", "café"})
+	matches := m.find([]rune("ushers at the café"))
+	require.Equal([]match{
+		{Pattern: 1, Start: 1, End: 4},
+		{Pattern: 0, Start: 2, End: 4},
+		{Pattern: 3, Start: 2, End: 6},
+		{Pattern: 0, Start: 11, End: 13},
+		{Pattern: 5, Start: 14, End: 18},
+	}, matches)
+	require.Empty(newMatcher(nil).find([]rune("anything")))
+}
@@ -0,0 +1,176 @@
+package backlinker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// pageAliases are the other names that a page can be linked by, from aliases in its
+// frontmatter (like aliases = ["JS", "ECMAScript"]). Aliases that start with a / are
+// URLs for Hugo rather than names (see redirectAliases).
+func pageAliases(file *markdownFile) []string {
+	names := make([]string, 0)
+	for _, alias := range metadataStrings(file.metadata, "aliases") {
+		if !strings.HasPrefix(alias, "/") {
+			names = append(names, alias)
+		}
+	}
+	return names
+}
+
+// redirectAliases turns the aliases in a page's frontmatter into Hugo aliases, which are
+// URLs that redirect to the page. Each name becomes the URL that a page with that name
+// would have had, so old links to a renamed page (see Rename) keep working. Hugo puts
+// relative aliases next to the page, in the same folder. URLs are kept as they are.
+func redirectAliases(file *markdownFile) {
+	aliases := metadataStrings(file.metadata, "aliases")
+	if len(aliases) == 0 {
+		return
+	}
+	own := pagePath(file)
+	seen := map[string]bool{own[len(own)-1]: true}
+	redirects := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if !strings.HasPrefix(alias, "/") {
+			alias = urlize(sanitizePageName(alias))
+		}
+		if !seen[alias] {
+			seen[alias] = true
+			redirects = append(redirects, alias)
+		}
+	}
+	file.metadata["aliases"] = redirects
+}
+
+// pageIndex finds the pages that wikilinks point to during a build. It holds the fileMap
+// (see createFileMapping), which gains the new pages that links create, along with the
+// index of the pages by their names (without the folder) and by their aliases.
+type pageIndex struct {
+	files   map[string]*markdownFile
+	names   map[string][]*markdownFile
+	aliases map[string]*markdownFile
+}
+
+// newPageIndex indexes the notes in the fileMap by their names. Notes with the same name
+// in different folders are kept in order of how deep they are, and then by their path,
+// so that the choice between them is always the same (see lookup).
+func newPageIndex(fileMap map[string]*markdownFile) *pageIndex {
+	files := sourceFiles(fileMap)
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i].OriginalName, "/") < strings.Count(files[j].OriginalName, "/")
+	})
+	names := make(map[string][]*markdownFile)
+	for _, file := range files {
+		key := strings.ToLower(path.Base(file.OriginalName))
+		names[key] = append(names[key], file)
+	}
+	return &pageIndex{files: fileMap, names: names, aliases: make(map[string]*markdownFile)}
+}
+
+// indexPages builds the index used by findPage to look up pages by their names and
+// aliases. It needs the frontmatter, so it's done once all of the pages have been parsed,
+// but before any backlinks are recorded (otherwise links to an alias would create a new
+// page). An alias can't be used by two pages, or be the name of another page.
+func indexPages(fileMap map[string]*markdownFile) (*pageIndex, error) {
+	pages := newPageIndex(fileMap)
+	for _, file := range sourceFiles(fileMap) {
+		for _, alias := range pageAliases(file) {
+			key := wikilink{Target: alias}.mappingName()
+			if others := pages.names[key]; len(others) > 0 {
+				if others[0] != file {
+					return nil, fmt.Errorf("alias %s of %s is the name of %s", alias, file.OriginalName,
+						others[0].OriginalName)
+				}
+				continue
+			}
+			if other, exists := pages.aliases[key]; exists && other != file {
+				return nil, fmt.Errorf("alias %s is used by both %s and %s", alias, other.OriginalName,
+					file.OriginalName)
+			}
+			pages.aliases[key] = file
+		}
+	}
+	return pages, nil
+}
+
+// lookup finds the note that a wikilink from the page called from points to by its name.
+// A link can include the folders of the note, like [[projects/README]], and otherwise a
+// note in the same folder as the page comes first. If there are still several notes that
+// the link could mean, the first of them is used, and the others are returned too.
+func (pages *pageIndex) lookup(link wikilink, from string) (*markdownFile, []*markdownFile) {
+	target := strings.ToLower(strings.TrimPrefix(link.Target, "/"))
+	if from != "" {
+		if file, exists := pages.files[path.Join(path.Dir(from), target)+".md"]; exists && !file.IsNew {
+			return file, nil
+		}
+	}
+	candidates := make([]*markdownFile, 0, 1)
+	for _, file := range pages.names[path.Base(target)+".md"] {
+		name := strings.ToLower(removeExtension(file.OriginalName))
+		if pages.files[file.mappingKey()] != file {
+			// Left out of the build (see applyPublishPolicy)
+			continue
+		}
+		if name == target && strings.Contains(target, "/") {
+			return file, nil
+		}
+		if name == target || strings.HasSuffix(name, "/"+target) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	return candidates[0], candidates[1:]
+}
+
+// fileNames lists the files for a message, like "README.md or projects/README.md".
+func fileNames(files []*markdownFile) string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.OriginalName
+	}
+	return strings.Join(names, " or ")
+}
+
+// findPage looks up the page that a wikilink from the page called from points to, by its
+// name (see lookup) or one of its aliases. A link that doesn't match either can still
+// point to a new page, which was created with the sanitized name (see findFile).
+func findPage(pages *pageIndex, link wikilink, from string) (*markdownFile, bool) {
+	if file, _ := pages.lookup(link, from); file != nil {
+		return file, true
+	}
+	if file, exists := pages.aliases[link.mappingName()]; exists {
+		return file, true
+	}
+	file, exists := pages.files[strings.ToLower(link.newPageName())]
+	return file, exists
+}
+
+// findFile looks up the file that a wikilink points to by its name, leaving out the
+// aliases. The note with the name as it's written comes first, and then the new page
+// that a link with the name would have created.
+func findFile(pages *pageIndex, link wikilink, from string) (*markdownFile, bool) {
+	if file, _ := pages.lookup(link, from); file != nil {
+		return file, true
+	}
+	file, exists := pages.files[strings.ToLower(link.newPageName())]
+	return file, exists
+}
+
+// page finds the page for the file with the name (the path of the file in the content
+// directory), or nil if there isn't one.
+func (pages *pageIndex) page(name string) *markdownFile {
+	file, exists := pages.files[strings.ToLower(name)]
+	if !exists || file.OriginalName != name {
+		return nil
+	}
+	return file
+}
+
+// mappingKey is the key of the file in the fileMap.
+func (file *markdownFile) mappingKey() string {
+	return strings.ToLower(file.OriginalName)
+}
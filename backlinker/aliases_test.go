@@ -0,0 +1,107 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAliases(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"JavaScript.md": "---\naliases: [JS, ECMAScript]\n---\nThe language\n",
+		"Notes.md":      "Learning [[JS]], also known as [[ecmascript|the standard]] and [[JavaScript]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	notes, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+	require.Nil(err)
+	require.Contains(string(notes), "Learning [JS](../javascript/), also known as "+
+		"[the standard](../javascript/) and [JavaScript](../javascript/)\n")
+	_, err = os.Stat(filepath.Join(destDir, "JS.md"))
+	require.True(os.IsNotExist(err), "No page is created for an alias")
+
+	fileMap, err := createFileMapping([]string{"JavaScript.md", "Notes.md"}, options)
+	require.Nil(err)
+	pages, err := collectBacklinks(sourceDir, fileMap, newBuildCache(), options)
+	require.Nil(err)
+	require.Len(fileMap, 2)
+	require.Len(fileMap["javascript.md"].BackLinks, 3)
+	page, exists := findPage(pages, wikilink{Target: "js"}, "Notes.md")
+	require.True(exists)
+	require.Equal("JavaScript.md", page.OriginalName)
+
+	report, err := Check(options)
+	require.Nil(err)
+	require.Empty(report.Problems)
+}
+
+func TestAliasCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		notes   map[string]string
+		message string
+	}{
+		{
+			"two pages",
+			map[string]string{
+				"JavaScript.md": "---\naliases: [JS]\n---\n",
+				"JSON.md":       "---\naliases: js\n---\n",
+			},
+			"alias JS is used by both JSON.md and JavaScript.md",
+		},
+		{
+			"page name",
+			map[string]string{
+				"JavaScript.md": "---\naliases: [JSON]\n---\n",
+				"JSON.md":       "The format\n",
+			},
+			"alias JSON of JavaScript.md is the name of JSON.md",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			sourceDir := writeTestFiles(t, tt.notes)
+			defer os.RemoveAll(sourceDir)
+			destDir := writeTestFiles(t, nil)
+			defer os.RemoveAll(destDir)
+
+			err := ProcessBackLinks(testBuildOptions(sourceDir, destDir))
+			require.NotNil(err)
+			require.Equal(tt.message, err.Error())
+		})
+	}
+}
+
+func TestRedirectsAndSlugs(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"projects/JavaScript.md": "---\naliases: [JS, \"/old/url/\", javascript]\nslug: Java Script\n---\nThe language\n",
+		"About.md":               "---\nurl: /about-us/\n---\nWho we are\n",
+		"Notes.md":               "Learning [[JS]], see [[About]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	notes, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+	require.Nil(err)
+	require.Contains(string(notes), "Learning [JS](../projects/java-script/), see [About](/about-us/)\n")
+	javascript, err := ioutil.ReadFile(filepath.Join(destDir, "projects", "JavaScript.md"))
+	require.Nil(err)
+	require.Contains(string(javascript), "aliases = [\"js\", \"/old/url/\", \"javascript\"]\n")
+	require.Contains(string(javascript), "* [Notes](../../notes/)\n")
+	about, err := ioutil.ReadFile(filepath.Join(destDir, "About.md"))
+	require.Nil(err)
+	require.NotContains(string(about), "aliases")
+}
@@ -3,6 +3,7 @@ package backlinker
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,23 +11,33 @@ import (
 	"log"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+)
 
-	wikilinks "github.com/dangoor/goldmark-wikilinks"
-	"github.com/naoina/toml"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/text"
-	"github.com/yuin/goldmark/util"
+// These are the kinds of backlinks: pages can be linked to ([[Page]]), embedded in
+// another page (![[Page]]) or used as a tag (#Page).
+const (
+	linkBacklink  = "link"
+	embedBacklink = "embed"
+	tagBacklink   = "tag"
+	// mentionBacklink is for unlinked mentions, where the title of the page appears in
+	// the text of another page without a link (see findUnlinkedMentions).
+	mentionBacklink = "mention"
 )
 
 // backlink is a link to a given markdownFile from another
 type backlink struct {
 	OtherFile *markdownFile
 	Context   string
+	// Section is the heading that the link pointed to, if it was a [[Page#Section]] link.
+	Section string
+	// Kind is linkBacklink, embedBacklink, tagBacklink or mentionBacklink.
+	Kind string
+	// Block is the id of the block that the link pointed to, for links to a block.
+	Block string
 }
 
 // markdownFile is the fundamental unit that this code works with.
@@ -34,6 +45,8 @@ type backlink struct {
 type markdownFile struct {
 	// I use lower case to look up files consistently,
 	// but want to remember the original case.
+	// OriginalName is the slash-separated path relative to the source directory,
+	// so that the same folder structure can be recreated in the destination.
 	OriginalName string
 
 	// Title defaults to a variation of the filename but can be overridden
@@ -42,38 +55,63 @@ type markdownFile struct {
 	BackLinks  []backlink
 	IsNew      bool
 	IsDateFile bool
+	IsPrivate  bool
 	newData    *bytes.Buffer
 	metadata   map[string]interface{}
 	firstLine  string
-	scanner    *bufio.Scanner
+
+	// frontmatterFormat is the format of the frontmatter found in the file, or empty
+	// if it had none.
+	frontmatterFormat string
+	// bodyLine is the number of lines taken up by the frontmatter.
+	bodyLine int
+	// body is the markdown after the frontmatter, without its private regions.
+	body []byte
+	// links are the wikilinks found in the body, in order.
+	links []wikilinkSpan
+	// mentions are the places where other pages have the title of this one without
+	// linking to it, if unlinked mentions are turned on.
+	mentions []backlink
 }
 
-// getFileList retrieves the list of markdown filenames for the source directory.
+// getFileList retrieves the list of markdown filenames for the source directory and all
+// of its subdirectories. The names are slash-separated and relative to sourceDir.
+// Hidden directories (like .git or .obsidian) are skipped.
 func getFileList(sourceDir string) ([]string, error) {
 	result := make([]string, 0)
-	fileInfos, err := ioutil.ReadDir(sourceDir)
+	err := filepath.Walk(sourceDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filename != sourceDir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(info.Name()) != ".md" {
+			return nil
+		}
+		relative, err := filepath.Rel(sourceDir, filename)
+		if err != nil {
+			return err
+		}
+		result = append(result, filepath.ToSlash(relative))
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	for _, fileInfo := range fileInfos {
-		if path.Ext(fileInfo.Name()) != ".md" {
-			continue
-		}
-		result = append(result, fileInfo.Name())
-	}
 	return result, nil
 }
 
 // createMarkdownFile safely creates a markdownFile struct
-func createMarkdownFile(originalFileName string, isNew bool) *markdownFile {
-	isDateFile, err := regexp.MatchString(`\d\d\d\d-\d\d-\d\d.md`, originalFileName)
-	if err != nil {
-		panic(fmt.Sprintf("Error when parsing date regex: %v", err))
-	}
+func createMarkdownFile(originalFileName string, isNew bool, options *Options) *markdownFile {
+	isDateFile := options.isDailyNote(path.Base(originalFileName))
 
 	return &markdownFile{
 		OriginalName: originalFileName,
-		Title:        removeExtension(originalFileName),
+		Title:        pageName(originalFileName),
 		BackLinks:    []backlink{},
 		IsNew:        isNew,
 		IsDateFile:   isDateFile,
@@ -83,106 +121,229 @@ func createMarkdownFile(originalFileName string, isNew bool) *markdownFile {
 }
 
 // createFileMapping takes a list of filenames (found via getFileList)
-// and returns a map from lower case filename to *markdownFile
-func createFileMapping(files []string) map[string]*markdownFile {
+// and returns a map from lower case filename to *markdownFile.
+// Files are keyed by their path in the content directory. Wikilinks find pages by
+// their name no matter which folder the page lives in (see pageIndex), so pages in
+// different folders can have the same name. Two files whose paths only differ in
+// case would be the same page, so that is an error.
+func createFileMapping(files []string, options *Options) (map[string]*markdownFile, error) {
 	result := make(map[string]*markdownFile)
 	for _, filename := range files {
-		file := createMarkdownFile(filename, false)
-		result[strings.ToLower(filename)] = file
+		file := createMarkdownFile(filename, false, options)
+		key := file.mappingKey()
+		if existing, exists := result[key]; exists {
+			return nil, fmt.Errorf("page name %s is used by both %s and %s",
+				removeExtension(path.Base(filename)), existing.OriginalName, filename)
+		}
+		result[key] = file
 	}
-	return result
+	return result, nil
+}
+
+// findOrCreatePage looks up the page that a wikilink from the page called from points to
+// (see findPage). Links to pages that don't exist yet create a new page, which will only be
+// filled with backlinks. The new page's filename is sanitized (see sanitizePageName), but
+// its title is the link text.
+func findOrCreatePage(pages *pageIndex, link wikilink, from string, options *Options) *markdownFile {
+	file, exists := findPage(pages, link, from)
+	if !exists {
+		file = createMarkdownFile(link.newPageName(), true, options)
+		file.Title = link.Target
+		pages.files[file.mappingKey()] = file
+	}
+	return file
 }
 
 // backlinkCollector is a goldmark-wikilinks plugin to (surprise!) collect backlinks.
 // When each file is processed, it keeps track of the file being processed and has
-// access to the mapping of other files.
+// access to the other pages. The links are found by parseMarkdown and
+// findWikilinks, and then handed to the collector (see recordBacklinks).
 type backlinkCollector struct {
 	currentFile *markdownFile
-	fileMap     map[string]*markdownFile
+	pages       *pageIndex
+	options     *Options
 }
 
-// LinkWithContext fulfills the goldmark-wikilinks tracker interface to keep track
-// of each wiki-style link that's discovered.
-func (blc backlinkCollector) LinkWithContext(destText string, destFilename string, context string) {
-	destFile, exists := blc.fileMap[destFilename]
-	if !exists {
-		destFile = createMarkdownFile(destText+".md", true)
-		blc.fileMap[destFilename] = destFile
+// addBacklink records the link from the current file on the page it links to.
+func (blc backlinkCollector) addBacklink(link wikilink, context string, kind string) {
+	if link.Target == "" {
+		// [[#Section]] links to the current page and isn't a backlink
+		return
+	}
+	from := blc.currentFile.OriginalName
+	if file, others := blc.pages.lookup(link, from); len(others) > 0 {
+		log.Printf("%s links to %s, which could be %s, so %s is used\n", from, link.Target,
+			fileNames(append([]*markdownFile{file}, others...)), file.OriginalName)
 	}
-	destFile.BackLinks = append(destFile.BackLinks, backlink{
+	destFile := findOrCreatePage(blc.pages, link, from, blc.options)
+	bl := backlink{
 		OtherFile: blc.currentFile,
 		Context:   context,
-	})
+		Section:   link.Fragment,
+		Kind:      kind,
+	}
+	if id := link.block(); id != "" {
+		bl.Section = ""
+		bl.Block = id
+	}
+	destFile.BackLinks = append(destFile.BackLinks, bl)
 }
 
-// Normalize fulfills the goldmark-wikilinks file normalizer interface to make sure links
-// can point to the correct file, regardless of how the link is written. File lookups in
-// this code are all done with a lower case name.
+// Normalize fulfills the goldmark-wikilinks file normalizer interface, which parseMarkdown
+// needs in order to parse the links. The pages that links point to (including the ones
+// that use an alias) are found later, by findPage.
 func (blc backlinkCollector) Normalize(linkText string) string {
-	return strings.ToLower(linkText) + ".md"
+	return parseWikilink(linkText).mappingName()
 }
 
-// collectBacklinksForFile parses the file with Goldmark and tracks all of the links found
-// in order to accumulate the backlinks.
+// parseBody parses the markdown body of a file with Goldmark and keeps track of all of the
+// links found in it. The private regions are removed from the body first (see redactPrivate).
 // Goldmark isn't used for generating HTML (Hugo does that), but I need to use a proper
 // parser in order to be able to get the context of each link that's discovered.
-func collectBacklinksForFile(fileMap map[string]*markdownFile, currentFile *markdownFile, filetext []byte) {
+func parseBody(file *markdownFile, body []byte) {
+	redacted, lines := redactPrivate(body)
+	file.body = redacted
+	file.links = findWikilinks(parseMarkdown(redacted), redacted)
+	for i, span := range file.links {
+		line := bytes.Count(redacted[:span.Start], []byte("\n"))
+		file.links[i].Line = file.bodyLine + 1 + lines[line]
+	}
+}
+
+// recordBacklinks adds a backlink to every page that currentFile links to.
+func recordBacklinks(pages *pageIndex, currentFile *markdownFile, options *Options) {
 	blc := backlinkCollector{
 		currentFile: currentFile,
-		fileMap:     fileMap,
+		pages:       pages,
+		options:     options,
 	}
+	for _, span := range currentFile.links {
+		kind := linkBacklink
+		switch {
+		case span.Embed:
+			kind = embedBacklink
+		case options.hashtagAsWikilink(span):
+			// This is a link like any other
+		case span.Hashtag && !options.tagPages():
+			continue
+		case span.Hashtag:
+			kind = tagBacklink
+		}
+		blc.addBacklink(span.Link, span.Context, kind)
+	}
+}
 
-	wl := wikilinks.NewWikilinksParser().WithTracker(blc).WithNormalizer(blc)
-	md := goldmark.New(
-		goldmark.WithParserOptions(
-			parser.WithInlineParsers(util.Prioritized(wl, 102)),
-		),
-	)
-	reader := text.NewReader(filetext)
-	md.Parser().Parse(reader)
+// collectBacklinksForFile parses the file with Goldmark and tracks all of the links found
+// in order to accumulate the backlinks.
+func collectBacklinksForFile(pages *pageIndex, currentFile *markdownFile, filetext []byte,
+	options *Options) {
+	parseBody(currentFile, filetext)
+	recordBacklinks(pages, currentFile, options)
 }
 
 // collectBacklinks loops through all of the files in the directory, parses each one,
-// and gathers the backlinks from that parsing.
-func collectBacklinks(sourceDir string, fileMap map[string]*markdownFile) error {
+// and gathers the backlinks from that parsing. The frontmatter is skipped, just as it is
+// when the links are converted.
+// Files which haven't changed since the last build are not parsed again. Their metadata
+// and links come from the cache instead, and the cache is updated with the rest.
+// The pages are returned with the index used to look them up (see indexPages).
+func collectBacklinks(sourceDir string, fileMap map[string]*markdownFile, cache *buildCache,
+	options *Options) (*pageIndex, error) {
 	for _, file := range fileMap {
 		if file.IsNew {
 			continue
 		}
 		filename := path.Join(sourceDir, file.OriginalName)
-		log.Printf("Collecting backlinks from %s\n", filename)
 		filetext, err := ioutil.ReadFile(filename)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		hash := contentHash(filetext)
+		if cache.restore(file, hash) {
+			body, err := bodyFromText(filetext, file.bodyLine)
+			if err != nil {
+				return nil, err
+			}
+			file.body, _ = redactPrivate(body)
+			continue
+		}
+
+		log.Printf("Collecting backlinks from %s\n", filename)
+		scanner := bufio.NewScanner(bytes.NewReader(filetext))
+		err = extractFrontmatter(file, scanner)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		body, err := bodyFromText(filetext, file.bodyLine)
+		if err != nil {
+			return nil, err
 		}
-		collectBacklinksForFile(fileMap, file, filetext)
+		parseBody(file, body)
+		cache.store(file, hash)
 	}
-	return nil
+
+	// Backlinks are recorded once all of the files are parsed, so that aliases and
+	// references to blocks in other files can be resolved
+	pages, err := indexPages(fileMap)
+	if err != nil {
+		return nil, err
+	}
+	resolveBlockRefs(fileMap)
+	for _, file := range sourceFiles(fileMap) {
+		recordBacklinks(pages, file, options)
+	}
+	return pages, nil
+}
+
+// sourceFiles returns the files that have a source file (as opposed to the pages that
+// only exist because they are linked to) in order of their names.
+func sourceFiles(fileMap map[string]*markdownFile) []*markdownFile {
+	result := make([]*markdownFile, 0, len(fileMap))
+	for _, file := range fileMap {
+		if !file.IsNew {
+			result = append(result, file)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OriginalName < result[j].OriginalName
+	})
+	return result
 }
 
 // extractFrontmatter reads the frontmatter from the file and adds it as the metadata property on
 // the `file` struct. It returns the first line of the file, in case there is no frontmatter.
+// TOML (+++), YAML (---) and JSON ({ ... }) frontmatter are all recognized.
 func extractFrontmatter(file *markdownFile, scanner *bufio.Scanner) error {
 	var front bytes.Buffer
 	first := true
 	noMeta := false
 	foundEnd := false
+	format := ""
+	lineCount := 0
 	var line string
 	for scanner.Scan() {
 		line = scanner.Text()
+		lineCount++
 		if first {
 			first = false
-			if line != "+++" {
+			format = detectFrontmatterFormat(line)
+			if format == "" {
 				noMeta = true
 				break
 			}
-			continue
-		}
-		if line == "+++" {
+			// The opening brace is part of the JSON, whereas the other fences are not
+			if format != FrontmatterJSON {
+				continue
+			}
+		} else if format != FrontmatterJSON && line == frontmatterFences[format] {
 			foundEnd = true
 			break
 		}
 		front.WriteString(line + "\n")
+		if format == FrontmatterJSON && json.Valid(front.Bytes()) {
+			foundEnd = true
+			break
+		}
 	}
 	err := scanner.Err()
 	if err != nil {
@@ -192,36 +353,41 @@ func extractFrontmatter(file *markdownFile, scanner *bufio.Scanner) error {
 		return errors.New("no end tag found in frontmatter")
 	}
 	meta := make(map[string]interface{})
-	if !noMeta {
-		err = toml.Unmarshal(front.Bytes(), meta)
+	if !noMeta && !first {
+		meta, err = parseFrontmatter(format, front.Bytes())
 		if err != nil {
 			return err
 		}
 	}
 	file.metadata = meta
-	if !noMeta {
+	file.frontmatterFormat = format
+	file.bodyLine = lineCount
+	if noMeta {
+		// The line that was read is part of the body
+		file.bodyLine = 0
+	} else {
 		line = ""
 	}
 	file.firstLine = line
 	return nil
 }
 
-// adjustFrontmatter will parse the frontmatter block (if present) and gather the TOML
-// metadata. It pulls out the title and applies it to the *markdownFile.
+// resolveTitleAndDate pulls the title out of the metadata and applies it to the
+// *markdownFile, or puts the file's title in the metadata if there isn't one.
 // If the file being processed has a filename that's just a date, that date is inserted into
-// the frontmatter.
-func adjustFrontmatter(file *markdownFile, writer io.Writer) error {
+// the metadata. Other files without a date get the date of their most recent backlink.
+func resolveTitleAndDate(file *markdownFile, options *Options) error {
 	meta := file.metadata
 
 	if file.IsDateFile {
 		_, hasTitle := meta["title"]
-		plainFilename := removeExtension(file.OriginalName)
+		plainFilename := pageName(file.OriginalName)
 		if !hasTitle {
 			meta["title"] = plainFilename
 		}
 		_, hasDate := meta["date"]
 		if !hasDate {
-			datetime, err := time.Parse(time.RFC3339, plainFilename+"T08:00:00Z")
+			datetime, err := options.dailyNoteDate(plainFilename)
 			if err != nil {
 				return err
 			}
@@ -231,7 +397,7 @@ func adjustFrontmatter(file *markdownFile, writer io.Writer) error {
 
 	title, hasTitle := meta["title"]
 	if hasTitle {
-		file.Title = title.(string)
+		file.Title = fmt.Sprint(title)
 	} else {
 		meta["title"] = file.Title
 	}
@@ -239,11 +405,10 @@ func adjustFrontmatter(file *markdownFile, writer io.Writer) error {
 	if meta["date"] == nil {
 		var latest time.Time
 		for _, backlink := range file.BackLinks {
-			otherDateInt, hasDate := backlink.OtherFile.metadata["date"]
+			otherDate, hasDate := metadataDate(backlink.OtherFile.metadata)
 			if !hasDate {
 				continue
 			}
-			otherDate := otherDateInt.(time.Time)
 			if otherDate.After(latest) {
 				latest = otherDate
 			}
@@ -252,16 +417,33 @@ func adjustFrontmatter(file *markdownFile, writer io.Writer) error {
 			meta["date"] = latest
 		}
 	}
+	return nil
+}
 
-	updatedMeta, err := toml.Marshal(meta)
+// adjustFrontmatter will take the metadata gathered from the frontmatter block (if present)
+// and write out the new frontmatter, after resolving the title and date (see
+// resolveTitleAndDate).
+// The frontmatter is written in the configured format, which can be FrontmatterSame to keep
+// the format the file was written in (new files and files without frontmatter get TOML).
+func adjustFrontmatter(file *markdownFile, options *Options, writer io.Writer) error {
+	err := resolveTitleAndDate(file, options)
 	if err != nil {
 		return err
 	}
-	writer.Write([]byte("+++\n"))
-	writer.Write(updatedMeta)
-	writer.Write([]byte("+++\n"))
 
-	return nil
+	format := options.FrontmatterFormat
+	if format == FrontmatterSame {
+		format = file.frontmatterFormat
+		if format == "" {
+			format = FrontmatterTOML
+		}
+	}
+	updatedMeta, err := formatFrontmatter(format, file.metadata)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(updatedMeta)
+	return err
 }
 
 // removeExtension is a simple utility that safely trims the extension from the filename
@@ -269,77 +451,179 @@ func removeExtension(filename string) string {
 	return strings.TrimSuffix(filename, path.Ext(filename))
 }
 
-// createHugoLink reformats a filename the way hugo does for it's links.
-// Hugo links will be to a sibling directory, with a lower case name, and spaces replaced
-// with hyphens.
-func createHugoLink(filename string) string {
-	name := removeExtension(filename)
-	name = strings.ToLower(name)
-	name = strings.ReplaceAll(name, " ", "-")
-	return "../" + name + "/"
+// pageName is the name of the page without its folder or extension
+func pageName(filename string) string {
+	return removeExtension(path.Base(filename))
+}
+
+// hugoPath reformats a filename the way hugo does for its URLs. Every folder and the
+// name itself go through urlize.
+func hugoPath(filename string) []string {
+	segments := strings.Split(removeExtension(filename), "/")
+	for i, segment := range segments {
+		segments[i] = urlize(segment)
+	}
+	return segments
+}
+
+// createHugoLink creates a relative link from the page generated for `from` to the page
+// generated for `to`. Hugo puts each page in its own directory, so a link between two
+// pages in the same folder goes to a sibling directory. Pages in other folders are
+// reached by climbing up to the closest shared folder. The path is relative to the
+// folder of `from`, and is dropped into the configured link format.
+func createHugoLink(from string, to string, options *Options) string {
+	return relativeHugoLink(from, hugoPath(to), options)
+}
+
+// relativeHugoLink creates the link from the page generated for `from` to the URL path
+// of another page (see createHugoLink).
+func relativeHugoLink(from string, target []string, options *Options) string {
+	fromDir := hugoPath(from)
+	return options.formatLink(relativePath(fromDir[:len(fromDir)-1], target))
+}
+
+// relativePath is the path from the folder fromDir to the target, both given as their
+// segments. It climbs up to the closest folder they share.
+func relativePath(fromDir []string, target []string) string {
+	common := 0
+	for common < len(fromDir) && common < len(target)-1 && fromDir[common] == target[common] {
+		common++
+	}
+	ups := len(fromDir) - common
+	return strings.Repeat("../", ups) + strings.Join(target[common:], "/")
 }
 
-// convertLinksOnLine does a simple regex-based replacement of wikilinks on a single line
-// of markdown text. Each wikilink is replaced by a standard markdown link.
-func convertLinksOnLine(line string, fileMap map[string]*markdownFile) string {
-	replacer := func(s string) string {
-		linkText := s[2 : len(s)-2]
+// pagePath is the URL path of the page for the file (see hugoPath). Like in Hugo, a slug
+// in the file's frontmatter takes the place of the file's name.
+func pagePath(file *markdownFile) []string {
+	target := hugoPath(file.OriginalName)
+	if slug, hasSlug := file.metadata["slug"].(string); hasSlug && urlize(slug) != "" {
+		target[len(target)-1] = urlize(slug)
+	}
+	return target
+}
 
-		expectedMappingName := strings.ToLower(linkText) + ".md"
-		file, exists := fileMap[expectedMappingName]
-		if !exists {
-			file = createMarkdownFile(linkText+".md", true)
-			fileMap[expectedMappingName] = file
+// convertLinksInText replaces each wikilink in the markdown text with a standard markdown
+// link relative to the page `from`, which is the page the text will appear on. The text
+// is parsed with goldmark to find the links, so wikilinks in code are left alone.
+// Aliased links ([[Target|Display text]]) show the alias but link to the target. Links to
+// a section ([[Target#Section]]) include the anchor for that heading.
+func convertLinksInText(markdown string, from string, pages *pageIndex,
+	options *Options) string {
+	source := []byte(markdown)
+	spans := findWikilinks(parseMarkdown(source), source)
+	return string(replaceLinks(source, spans, from, pages, options))
+}
+
+// replaceLinks replaces the wikilinks at each of the spans with markdown links. Block
+// markers are dropped, since the text is shown somewhere other than its own page.
+func replaceLinks(source []byte, spans []wikilinkSpan, from string, pages *pageIndex,
+	options *Options) []byte {
+	var result bytes.Buffer
+	last := 0
+	for _, span := range spans {
+		result.Write(source[last:span.Start])
+		if span.Block == "" {
+			result.WriteString(spanMarkdown(span, from, pages, options))
 		}
-		linkTo := createHugoLink(file.OriginalName)
-		return fmt.Sprintf("[%s](%s)", linkText, linkTo)
+		last = span.Stop
 	}
-	re := regexp.MustCompile(`\[\[[^\]]+\]\]`)
-	return re.ReplaceAllStringFunc(line, replacer)
+	result.Write(source[last:])
+	return result.Bytes()
 }
 
-// convertLinks consumes the file through the scanner, replacing all of the wikilinks in
-// the file with the proper markdown links.
-func convertLinks(firstLine string, scanner *bufio.Scanner, fileMap map[string]*markdownFile,
-	writer io.Writer) error {
-	if firstLine != "" {
-		updatedLine := convertLinksOnLine(firstLine, fileMap) + "\n"
-		_, err := writer.Write([]byte(updatedLine))
-		if err != nil {
-			return err
+// spanMarkdown is the markdown that replaces a wikilink. References to blocks show the
+// text of the block, and everything else is a markdown link.
+func spanMarkdown(span wikilinkSpan, from string, pages *pageIndex, options *Options) string {
+	if span.BlockRef && span.Link.Target == "" {
+		// Block references in the context of a backlink haven't been resolved yet
+		owner := findBlockOwner(pages.files, span.Text)
+		if owner == nil {
+			// The block couldn't be found, so there's nothing to link to
+			return span.Link.Display
 		}
+		span.Link.Target = pageName(owner.OriginalName)
+	}
+	if options.hashtagAsWikilink(span) {
+		link := span.Link
+		link.Display = span.Text
+		return "#" + markdownLink(link, from, pages, options)
+	}
+	if span.Hashtag && !options.tagPages() {
+		return span.Link.Display
+	}
+	if quote, isBlock := blockQuote(span, from, pages, options); isBlock {
+		return quote
+	}
+	return markdownLink(span.Link, from, pages, options)
+}
+
+// markdownLink creates the standard markdown link for a wikilink, creating the target
+// page if it doesn't exist yet.
+func markdownLink(link wikilink, from string, pages *pageIndex, options *Options) string {
+	if link.Target == "" {
+		return fmt.Sprintf("[%s](#%s)", link.Display, link.anchor())
+	}
+
+	file := findOrCreatePage(pages, link, from, options)
+	if file.IsPrivate {
+		return link.Display
+	}
+	linkTo := pageLink(from, file, pages, options)
+	if link.Fragment != "" {
+		linkTo += "#" + link.anchor()
+	}
+	return fmt.Sprintf("[%s](%s)", link.Display, linkTo)
+}
+
+// readBody consumes the rest of the file (everything after the frontmatter) from the scanner.
+func readBody(firstLine string, scanner *bufio.Scanner) ([]byte, error) {
+	var body bytes.Buffer
+	if firstLine != "" {
+		body.WriteString(firstLine + "\n")
 	}
 	for scanner.Scan() {
-		line := scanner.Text()
-		updatedLine := convertLinksOnLine(line, fileMap) + "\n"
-		_, err := writer.Write([]byte(updatedLine))
-		if err != nil {
-			return err
-		}
+		body.WriteString(scanner.Text() + "\n")
 	}
 	err := scanner.Err()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return body.Bytes(), nil
 }
 
-// addBacklinks tacks additional markdown onto the file with the collection of backlink
-// references.
-func addBacklinks(file *markdownFile, fileMap map[string]*markdownFile, writer io.Writer) error {
-	if len(file.BackLinks) == 0 {
-		return nil
+// bodyFromText finds the body of the file text, given the number of lines that the
+// frontmatter takes up (see extractFrontmatter).
+func bodyFromText(filetext []byte, bodyLine int) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(filetext))
+	for i := 0; i < bodyLine && scanner.Scan(); i++ {
+	}
+	firstLine := ""
+	if bodyLine == 0 && scanner.Scan() {
+		firstLine = scanner.Text()
 	}
-	writer.Write([]byte(`
-## Backlinks
+	return readBody(firstLine, scanner)
+}
+
+// convertLinks replaces all of the wikilinks in the body of the file with the proper
+// markdown links, and the embeds with what they embed.
+func convertLinks(file *markdownFile, pages *pageIndex, options *Options,
+	writer io.Writer) error {
+	converted := expandLinks(file.body, file.links, file.OriginalName, pages, options,
+		[]string{file.OriginalName}, logEmbedProblem)
+	_, err := writer.Write(converted)
+	return err
+}
 
-`))
-	sort.Slice(file.BackLinks, func(i, j int) bool {
-		bl1 := file.BackLinks[i]
-		bl2 := file.BackLinks[j]
+// sortBacklinks puts the most recent backlinks first, followed by the backlinks from
+// pages without dates in order of their titles.
+func sortBacklinks(backlinks []backlink) {
+	sort.Slice(backlinks, func(i, j int) bool {
+		bl1 := backlinks[i]
+		bl2 := backlinks[j]
 
-		dateField1, hasDateField1 := bl1.OtherFile.metadata["date"]
-		dateField2, hasDateField2 := bl2.OtherFile.metadata["date"]
+		date1, hasDateField1 := metadataDate(bl1.OtherFile.metadata)
+		date2, hasDateField2 := metadataDate(bl2.OtherFile.metadata)
 
 		if hasDateField1 && !hasDateField2 {
 			return true
@@ -348,74 +632,143 @@ func addBacklinks(file *markdownFile, fileMap map[string]*markdownFile, writer i
 		}
 
 		if hasDateField1 && hasDateField2 {
-			date1 := dateField1.(time.Time)
-			date2 := dateField2.(time.Time)
 			return date1.After(date2)
 		}
 
 		return strings.Compare(bl1.OtherFile.Title, bl2.OtherFile.Title) < 0
 	})
+}
 
+// addBacklinks tacks additional markdown onto the file with the collection of backlink
+// references and unlinked mentions. The markdown comes from the backlinks template.
+func addBacklinks(file *markdownFile, pages *pageIndex, options *Options, writer io.Writer) error {
+	if len(file.BackLinks) == 0 && len(file.mentions) == 0 {
+		return nil
+	}
+	sortBacklinks(file.BackLinks)
+	sortBacklinks(file.mentions)
+
+	data := backlinksData{
+		Heading:         options.BacklinksHeading,
+		MentionsHeading: options.UnlinkedMentionsHeading,
+		Page:            newPageData(file, file.OriginalName, pages, options),
+		Backlinks:       make([]backlinkData, 0, len(file.BackLinks)),
+		Mentions:        make([]backlinkData, 0, len(file.mentions)),
+	}
 	for _, backlink := range file.BackLinks {
-		title := backlink.OtherFile.Title
-		link := createHugoLink(backlink.OtherFile.OriginalName)
-		context := convertLinksOnLine(backlink.Context, fileMap)
-		writer.Write([]byte(fmt.Sprintf(`* [%s](%s)
-    * %s
-`, title, link, context)))
+		data.Backlinks = append(data.Backlinks, newBacklinkData(file, backlink, pages, options))
 	}
-	return nil
+	for _, mention := range file.mentions {
+		data.Mentions = append(data.Mentions, newBacklinkData(file, mention, pages, options))
+	}
+	return options.backlinksTemplate.Execute(writer, data)
 }
 
-// generateFileData steps through all of the files and reads in their data, converting
-// wikilinks and adding backlinks
-func generateFileData(sourceDir string, fileMap map[string]*markdownFile) error {
-	for _, file := range fileMap {
-		file.newData = bytes.NewBuffer([]byte{})
-		filename := path.Join(sourceDir, file.OriginalName)
-		var scanner *bufio.Scanner
-		if file.IsNew {
-			log.Printf("%s is a new file\n", filename)
-			scanner = bufio.NewScanner(strings.NewReader(""))
-		} else {
-			log.Printf("Reading %s\n", filename)
-			fileOnDisk, err := os.Open(filename)
-			if err != nil {
-				return err
-			}
-			scanner = bufio.NewScanner(fileOnDisk)
+// addBacklinksToMetadata puts the backlinks into the file's metadata rather than its
+// body, so that a Hugo theme can render them from .Params.backlinks (and the unlinked
+// mentions from .Params.unlinked_mentions).
+// Hugo doesn't expand shortcodes in .Params, so with the ref link strategy the links in
+// the metadata start from the root of the site instead.
+func addBacklinksToMetadata(file *markdownFile, pages *pageIndex, options *Options) {
+	if options.LinkStrategy == LinksRef {
+		rootOptions := *options
+		rootOptions.LinkStrategy = LinksRoot
+		options = &rootOptions
+	}
+	if len(file.BackLinks) > 0 {
+		sortBacklinks(file.BackLinks)
+		file.metadata["backlinks"] = backlinkEntries(file, file.BackLinks, pages, options)
+	}
+	if len(file.mentions) > 0 {
+		sortBacklinks(file.mentions)
+		file.metadata["unlinked_mentions"] = backlinkEntries(file, file.mentions, pages, options)
+	}
+}
+
+// backlinkEntries turns backlinks into the data that goes in the frontmatter.
+func backlinkEntries(file *markdownFile, backlinks []backlink, pages *pageIndex,
+	options *Options) []map[string]interface{} {
+	entries := make([]map[string]interface{}, 0, len(backlinks))
+	for _, backlink := range backlinks {
+		data := newBacklinkData(file, backlink, pages, options)
+		entry := map[string]interface{}{
+			"title":       data.Title,
+			"url":         data.URL,
+			"context":     data.Context,
+			"raw_context": data.RawContext,
 		}
-		file.scanner = scanner
-		err := extractFrontmatter(file, scanner)
-		if err != nil {
-			return err
+		if !data.Date.IsZero() {
+			entry["date"] = data.Date
+		}
+		if data.Section != "" {
+			entry["section"] = data.Section
+		}
+		if data.Kind != linkBacklink && data.Kind != mentionBacklink {
+			entry["kind"] = data.Kind
 		}
+		if data.Block != "" {
+			entry["block"] = data.Block
+		}
+		entries = append(entries, entry)
 	}
+	return entries
+}
 
+// resolveTitles resolves the title and date of all of the published pages (see
+// resolveTitleAndDate).
+func resolveTitles(fileMap map[string]*markdownFile, options *Options) error {
 	// Process all of the date files first, in order to improve the reliability of
 	// finding a date for files that don't have them (especially the files
 	// which are generated just for backlinks).
 	// See https://github.com/dangoor/sharedbrain/issues/2
 	for _, file := range fileMap {
-		if file.IsDateFile {
-			err := adjustFrontmatter(file, file.newData)
+		if file.IsDateFile && !file.IsPrivate {
+			err := resolveTitleAndDate(file, options)
 			if err != nil {
 				return err
 			}
 		}
 	}
-
 	for _, file := range fileMap {
-		// We still need to adjust frontmatter for non-date files
-		if !file.IsDateFile {
-			err := adjustFrontmatter(file, file.newData)
+		if !file.IsDateFile && !file.IsPrivate {
+			err := resolveTitleAndDate(file, options)
 			if err != nil {
 				return err
 			}
 		}
+	}
+	return nil
+}
+
+// generateFileData steps through all of the files and generates their new data, converting
+// wikilinks and adding backlinks. The files were already read by collectBacklinks, and
+// their titles resolved by publishNotes.
+func generateFileData(pages *pageIndex, options *Options) error {
+	for _, file := range pages.files {
+		file.newData = bytes.NewBuffer([]byte{})
+	}
+	// Mentions are found by title, which is why the titles are resolved first
+	if options.UnlinkedMentions {
+		findUnlinkedMentions(pages.files)
+	}
+
+	for _, file := range pages.files {
+		if file.IsPrivate {
+			continue
+		}
+		// Aliases have been used to find the pages, so they can become redirects
+		redirectAliases(file)
+		// The titles need to be known before backlinks are added to the frontmatter
+		if options.BacklinksMode == BacklinksFrontmatter {
+			addBacklinksToMetadata(file, pages, options)
+		}
+		err := adjustFrontmatter(file, options, file.newData)
+		if err != nil {
+			return err
+		}
 
 		// All files need their links converted
-		err := convertLinks(file.firstLine, file.scanner, fileMap, file.newData)
+		err = convertLinks(file, pages, options, file.newData)
 		if err != nil {
 			return err
 		}
@@ -423,61 +776,158 @@ func generateFileData(sourceDir string, fileMap map[string]*markdownFile) error
 
 	// Backlinks need to be added after adjustFrontmatter has run in order to ensure
 	// that the backlink titles are correct
-	for _, file := range fileMap {
-		err := addBacklinks(file, fileMap, file.newData)
-		if err != nil {
-			return err
+	if options.BacklinksMode == BacklinksMarkdown {
+		for _, file := range pages.files {
+			if file.IsPrivate {
+				continue
+			}
+			err := addBacklinks(file, pages, options, file.newData)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-// writeFiles takes the fully processed fileMap and simply writes all of the new files
-// to disk
+// writeFiles takes the fully processed fileMap and writes all of the new files to disk,
+// recreating the source folder structure under destDir. Nothing is ever written outside
+// of destDir. Files that already have the right contents are left alone, so that tools
+// watching destDir only see the files which really changed.
 func writeFiles(destDir string, fileMap map[string]*markdownFile) error {
+	written := 0
+	published := 0
 	for _, file := range fileMap {
-		writer, err := os.Create(path.Join(destDir, file.OriginalName))
+		if file.IsPrivate {
+			continue
+		}
+		published++
+		filename, err := destPath(destDir, file.OriginalName)
+		if err != nil {
+			return err
+		}
+		existing, err := ioutil.ReadFile(filename)
+		if err == nil && bytes.Equal(existing, file.newData.Bytes()) {
+			continue
+		}
+		writer, err := os.Create(filename)
 		if err != nil {
 			return err
 		}
 		_, err = writer.Write(file.newData.Bytes())
 		if err != nil {
+			writer.Close()
 			return err
 		}
 		err = writer.Close()
 		if err != nil {
 			return err
 		}
+		written++
 	}
+	log.Printf("Wrote %d of %d files\n", written, published)
 	return nil
 }
 
+// readNotes finds all of the notes in options.Content and collects their backlinks,
+// which is where each of the commands starts. Notes which haven't changed since the last
+// build come from the cache (if it's turned on and there is a destination directory to
+// keep it in), and the cache is returned so that it can be saved. The options have to be
+// checked first (see Validate).
+func readNotes(options *Options) (*pageIndex, *buildCache, error) {
+	files, err := getFileList(options.Content)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileMap, err := createFileMapping(files, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache := newBuildCache()
+	if options.Cache && options.Dest != "" {
+		cache = loadBuildCache(options.Dest)
+	}
+	pages, err := collectBacklinks(options.Content, fileMap, cache, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pages, cache, nil
+}
+
+// publishNotes gets the notes ready to be published: the private notes are left out (see
+// applyPublishPolicy), #tags are added to the tags in the frontmatter (see mergeHashtags)
+// and the titles and dates of the other pages are resolved. Only the tags in the
+// frontmatter decide whether a note is published, not a #tag in passing in its text.
+func publishNotes(pages *pageIndex, options *Options) error {
+	applyPublishPolicy(pages.files, options)
+	mergeHashtags(pages.files, options)
+	return resolveTitles(pages.files, options)
+}
+
 // ProcessBackLinks converts markdown files with backlinks to new markdown files that cross-reference
-// properly.
+// properly. The markdown files are read from options.Content and written to options.Dest.
 //
 // There are four steps:
-// 1. Collect filenames so that link case can be normalized
-// 2. Parse the file with goldmark to collect the backlinks and their context
+// 1. Collect filenames (from the whole source tree) so that link case can be normalized
+// 2. Parse the file with goldmark to collect the backlinks and their context (files which
+//    haven't changed since the last build come from the cache instead)
 // 3. Write out the new file, including files that are only backlinks because they have no
 //    content of their own:
 //    a. Adjusted frontmatter
 //    b. Text with links changed
 //    c. Backlinks
-func ProcessBackLinks(sourceDir string, destDir string) error {
-	files, err := getFileList(sourceDir)
+// 4. Remove the files from earlier builds which are no longer generated (see ManifestFile)
+func ProcessBackLinks(options *Options) error {
+	err := options.Validate()
 	if err != nil {
-		return nil
+		return err
 	}
-	fileMap := createFileMapping(files)
-	err = collectBacklinks(sourceDir, fileMap)
+	pages, cache, err := readNotes(options)
 	if err != nil {
 		return err
 	}
-	err = generateFileData(sourceDir, fileMap)
+	// The cache has to be saved before the metadata is adjusted for the new files
+	cacheData, err := cache.encode()
 	if err != nil {
 		return err
 	}
-	err = writeFiles(destDir, fileMap)
-	return err
+	err = publishNotes(pages, options)
+	if err != nil {
+		return err
+	}
+	fileMap := pages.files
+	err = generateFileData(pages, options)
+	if err != nil {
+		return err
+	}
+	err = writeGraphOutput(fileMap, options)
+	if err != nil {
+		return err
+	}
+	err = writeLocalGraphs(fileMap, options)
+	if err != nil {
+		return err
+	}
+	previous, err := loadManifest(options.Dest)
+	if err != nil {
+		return err
+	}
+	err = writeFiles(options.Dest, fileMap)
+	if err != nil {
+		return err
+	}
+	generated := newManifest(fileMap)
+	err = removeStaleFiles(options.Dest, previous, generated)
+	if err != nil {
+		return err
+	}
+	err = saveManifest(options.Dest, generated)
+	if err != nil {
+		return err
+	}
+	if options.Cache {
+		return saveBuildCache(options.Dest, cacheData)
+	}
+	return nil
 }
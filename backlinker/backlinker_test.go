@@ -3,6 +3,9 @@ package backlinker
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -10,10 +13,22 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// testOptions are the default options, which match the original behavior of sharedbrain.
+var testOptions = DefaultOptions()
+
+// testBuildOptions are the default options for building from sourceDir to destDir.
+func testBuildOptions(sourceDir string, destDir string) *Options {
+	options := DefaultOptions()
+	options.Content = sourceDir
+	options.Dest = destDir
+	return options
+}
+
 func TestCreateFileMapping(t *testing.T) {
 	require := require.New(t)
 	files := []string{"First.md", "Second.md", "third.md", "2020-04-26.md"}
-	result := createFileMapping(files)
+	result, err := createFileMapping(files, testOptions)
+	require.Nil(err)
 	require.Equal(4, len(result))
 	third, exists := result["third.md"]
 	require.True(exists, "third.md should be in the map")
@@ -29,6 +44,137 @@ func TestCreateFileMapping(t *testing.T) {
 	require.True(datefile.IsDateFile, "Should be marked as a date file")
 }
 
+// writeTestFiles creates a temporary source directory holding the given files. The caller
+// is responsible for removing it.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(t, err)
+	for name, content := range files {
+		filename := path.Join(dir, name)
+		require.Nil(t, os.MkdirAll(path.Dir(filename), 0755))
+		require.Nil(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestGetFileListIsRecursive(t *testing.T) {
+	require := require.New(t)
+	dir := writeTestFiles(t, map[string]string{
+		"Top.md":                  "",
+		"notes.txt":               "",
+		"projects/Phoenix.md":     "",
+		"people/team/Someone.md":  "",
+		".obsidian/workspace.md":  "",
+		"journal/2020-04-26.md":   "",
+		"journal/attachments.pdf": "",
+	})
+	defer os.RemoveAll(dir)
+
+	files, err := getFileList(dir)
+	require.Nil(err)
+	require.ElementsMatch([]string{
+		"Top.md", "projects/Phoenix.md", "people/team/Someone.md", "journal/2020-04-26.md",
+	}, files)
+}
+
+func TestCreateFileMappingWithFolders(t *testing.T) {
+	require := require.New(t)
+	result, err := createFileMapping([]string{"projects/Phoenix.md", "journal/2020-04-26.md"}, testOptions)
+	require.Nil(err)
+	phoenix, exists := findPage(newPageIndex(result), wikilink{Target: "phoenix"}, "Top.md")
+	require.True(exists, "Pages in folders should be found by page name")
+	require.Equal("projects/Phoenix.md", phoenix.OriginalName)
+	require.Equal("Phoenix", phoenix.Title)
+	require.True(result["journal/2020-04-26.md"].IsDateFile, "Date files in folders are still date files")
+
+	_, err = createFileMapping([]string{"projects/Phoenix.md", "people/phoenix.md"}, testOptions)
+	require.Nil(err, "Pages in different folders can have the same name")
+	_, err = createFileMapping([]string{"projects/Phoenix.md", "Projects/phoenix.md"}, testOptions)
+	require.NotNil(err, "Paths which only differ in case are the same page")
+}
+
+func TestSameNameInDifferentFolders(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"README.md":          "About [[README]] and [[projects/README]]\n",
+		"projects/README.md": "The projects, see [[README]]\n",
+		"work/README.md":     "Work, see [[projects/readme|the projects]]\n",
+		"people/Someone.md":  "Read [[README]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	top, err := ioutil.ReadFile(path.Join(destDir, "README.md"))
+	require.Nil(err)
+	require.Contains(string(top), "About [README](../readme/) and [projects/README](../projects/readme/)\n")
+	projects, err := ioutil.ReadFile(path.Join(destDir, "projects/README.md"))
+	require.Nil(err)
+	require.Contains(string(projects), "The projects, see [README](../readme/)\n",
+		"A page in the same folder comes first")
+	work, err := ioutil.ReadFile(path.Join(destDir, "work/README.md"))
+	require.Nil(err)
+	require.Contains(string(work), "Work, see [the projects](../../projects/readme/)\n")
+	someone, err := ioutil.ReadFile(path.Join(destDir, "people/Someone.md"))
+	require.Nil(err)
+	require.Contains(string(someone), "Read [README](../../readme/)\n",
+		"Otherwise the page nearest to the top is used")
+
+	report, err := Check(options)
+	require.Nil(err)
+	require.Equal([]Problem{{
+		Rule:     RuleBrokenLinks,
+		Severity: SeverityError,
+		File:     "people/Someone.md",
+		Line:     1,
+		Message:  `link to "README" could be README.md or projects/README.md or work/README.md (README.md is used)`,
+	}}, report.Problems)
+	_, err = Rename(options, "README", "Overview", false, false)
+	require.Equal("page README could be README.md or projects/README.md or work/README.md, "+
+		"so it needs its folder", err.Error())
+	edits, err := Rename(options, "projects/README", "Overview", false, true)
+	require.Nil(err)
+	require.Len(edits, 3)
+	require.Contains(edits[0].Diff(), "+About [[README]] and [[projects/Overview]]\n")
+	require.Equal("projects/Overview.md", edits[1].NewName)
+	require.Contains(edits[1].Diff(), "+The projects, see [[Overview]]\n")
+	require.Contains(edits[2].Diff(), "+Work, see [[projects/overview|the projects]]\n")
+}
+
+func TestCreateHugoLink(t *testing.T) {
+	require := require.New(t)
+	require.Equal("../second/", createHugoLink("First.md", "Second.md", testOptions))
+	require.Equal("../name-with-spaces/", createHugoLink("First.md", "Name With Spaces.md", testOptions))
+	require.Equal("../phoenix/", createHugoLink("projects/Ash.md", "projects/Phoenix.md", testOptions))
+	require.Equal("../projects/phoenix/", createHugoLink("First.md", "projects/Phoenix.md", testOptions))
+	require.Equal("../../first/", createHugoLink("projects/Phoenix.md", "First.md", testOptions))
+	require.Equal("../../../projects/big-ideas/phoenix/",
+		createHugoLink("people/team/Someone.md", "Projects/Big Ideas/Phoenix.md", testOptions))
+}
+
+func TestProcessBackLinksMirrorsFolders(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"projects/Phoenix.md": "The [[Phoenix]] project, run by [[Someone]]\n",
+		"people/Someone.md":   "Just a person\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(err)
+	defer os.RemoveAll(destDir)
+
+	err = ProcessBackLinks(testBuildOptions(sourceDir, destDir))
+	require.Nil(err)
+	phoenix, err := ioutil.ReadFile(path.Join(destDir, "projects/Phoenix.md"))
+	require.Nil(err)
+	require.Contains(string(phoenix), "run by [Someone](../../people/someone/)")
+	someone, err := ioutil.ReadFile(path.Join(destDir, "people/Someone.md"))
+	require.Nil(err)
+	require.Contains(string(someone), "* [Phoenix](../../projects/phoenix/)")
+}
+
 func TestCollectBacklinksForFile(t *testing.T) {
 	require := require.New(t)
 	fileMap := map[string]*markdownFile{
@@ -37,13 +183,13 @@ func TestCollectBacklinksForFile(t *testing.T) {
 		"third.md":  {OriginalName: "Third.md", BackLinks: make([]backlink, 0)},
 	}
 
-	collectBacklinksForFile(fileMap, fileMap["first.md"], []byte(`
+	collectBacklinksForFile(newPageIndex(fileMap), fileMap["first.md"], []byte(`
 * This is a line with no links
 * This is a line [with a regular link](https://google.com)
 * This is a line with a link to [[second]]
 * This is another line with no links
 * This links to an [[Unknown]]
-`))
+`), testOptions)
 	second := fileMap["second.md"]
 	require.Equal(1, len(second.BackLinks))
 	bl := second.BackLinks[0]
@@ -82,9 +228,8 @@ date = 2019-08-26T19:34:48-04:00
 `
 	scanner := bufio.NewScanner(strings.NewReader(inputText))
 	writer := bytes.Buffer{}
-	file.scanner = scanner
 	err := extractFrontmatter(&file, scanner)
-	err = adjustFrontmatter(&file, &writer)
+	err = adjustFrontmatter(&file, testOptions, &writer)
 	require.Nil(err)
 	require.Equal("", file.firstLine)
 	output := writer.String()
@@ -96,17 +241,16 @@ date = 2019-08-26T19:34:48-04:00
 
 func TestFrontmatterForDatePages(t *testing.T) {
 	require := require.New(t)
-	file := createMarkdownFile("2020-04-19.md", false)
+	file := createMarkdownFile("2020-04-19.md", false, testOptions)
 	inputText := `## This is an example
 
 ... of a typical date page.
 `
 	scanner := bufio.NewScanner(strings.NewReader(inputText))
 	writer := bytes.Buffer{}
-	file.scanner = scanner
 	err := extractFrontmatter(file, scanner)
 	require.Nil(err)
-	err = adjustFrontmatter(file, &writer)
+	err = adjustFrontmatter(file, testOptions, &writer)
 	require.Nil(err)
 	require.Equal("## This is an example", file.firstLine)
 	output := writer.String()
@@ -124,13 +268,13 @@ func TestDateAddedBasedOnBacklinks(t *testing.T) {
 			"date": timestamp,
 		},
 	}
-	file := createMarkdownFile("Unknown.md", true)
+	file := createMarkdownFile("Unknown.md", true, testOptions)
 	file.BackLinks = append(file.BackLinks, backlink{
 		OtherFile: &otherFile,
 		Context:   "Linking to [[Unknown]]",
 	})
 	writer := bytes.Buffer{}
-	err := adjustFrontmatter(file, &writer)
+	err := adjustFrontmatter(file, testOptions, &writer)
 	require.Nil(err)
 	output := writer.String()
 	require.True(strings.HasPrefix(output, "+++\n"))
@@ -139,14 +283,14 @@ func TestDateAddedBasedOnBacklinks(t *testing.T) {
 
 func TestNoDateAddedIfBacklinkHasNoDate(t *testing.T) {
 	require := require.New(t)
-	otherFile := createMarkdownFile("NotImportant.md", false)
-	file := createMarkdownFile("Unknown.md", true)
+	otherFile := createMarkdownFile("NotImportant.md", false, testOptions)
+	file := createMarkdownFile("Unknown.md", true, testOptions)
 	file.BackLinks = append(file.BackLinks, backlink{
 		OtherFile: otherFile,
 		Context:   "Linking to [[Unknown]]",
 	})
 	writer := bytes.Buffer{}
-	err := adjustFrontmatter(file, &writer)
+	err := adjustFrontmatter(file, testOptions, &writer)
 	require.Nil(err)
 	output := writer.String()
 	require.True(strings.HasPrefix(output, "+++\n"))
@@ -166,26 +310,25 @@ date = 2019-08-26
 `
 	scanner := bufio.NewScanner(strings.NewReader(inputText))
 	writer := bytes.Buffer{}
-	file.scanner = scanner
-	err := extractFrontmatter(&file, file.scanner)
+	err := extractFrontmatter(&file, scanner)
 	require.Nil(err)
-	err = adjustFrontmatter(&file, &writer)
+	err = adjustFrontmatter(&file, testOptions, &writer)
 	require.Nil(err)
 	require.Equal("", file.firstLine)
 	output := writer.String()
 	require.Contains(output, "date = 2019-08-26T00:00:00Z")
 }
 
-func TestConvertLinksOnLine(t *testing.T) {
+func TestConvertLinksInText(t *testing.T) {
 	require := require.New(t)
 	fileMap := map[string]*markdownFile{
 		"first.md":            {OriginalName: "First.md", BackLinks: make([]backlink, 0)},
 		"second.md":           {OriginalName: "Second.md", BackLinks: make([]backlink, 0)},
 		"third.md":            {OriginalName: "Third.md", BackLinks: make([]backlink, 0)},
-		"name with spaces.md": createMarkdownFile("Name With Spaces.md", false),
+		"name with spaces.md": createMarkdownFile("Name With Spaces.md", false, testOptions),
 	}
 	line := "This line links to [[First]] and [[third]] and [[name with spaces]]."
-	result := convertLinksOnLine(line, fileMap)
+	result := convertLinksInText(line, "Second.md", newPageIndex(fileMap), testOptions)
 	require.Equal("This line links to [First](../first/) and [third](../third/) and [name with spaces](../name-with-spaces/).", result)
 }
 
@@ -195,7 +338,7 @@ func TestConvertLinksForUnknownFile(t *testing.T) {
 		"first.md": {OriginalName: "First.md", Title: "First", BackLinks: make([]backlink, 0)},
 	}
 	line := "This line links to [[Unknown]]!"
-	result := convertLinksOnLine(line, fileMap)
+	result := convertLinksInText(line, "First.md", newPageIndex(fileMap), testOptions)
 	require.Equal("This line links to [Unknown](../unknown/)!", result)
 	unknown, exists := fileMap["unknown.md"]
 	require.True(exists, "Unknown file should have been created")
@@ -214,9 +357,10 @@ func TestConvertLinks(t *testing.T) {
 * And here's a reference to [[Second]] and [[third]]
 * And another [[second]]
 `
-	scanner := bufio.NewScanner(strings.NewReader(inputText))
+	file := createMarkdownFile("First.md", false, testOptions)
+	parseBody(file, []byte(inputText))
 	writer := bytes.Buffer{}
-	err := convertLinks("", scanner, fileMap, &writer)
+	err := convertLinks(file, newPageIndex(fileMap), testOptions, &writer)
 	require.Nil(err)
 	output := writer.String()
 	require.Equal(`## This is a heading
@@ -225,6 +369,44 @@ func TestConvertLinks(t *testing.T) {
 `, output)
 }
 
+func TestConvertLinksSkipsCode(t *testing.T) {
+	require := require.New(t)
+	fileMap := map[string]*markdownFile{
+		"first.md": createMarkdownFile("First.md", false, testOptions),
+	}
+	inputText := "Real link to [[First]] and `[[Inline Code]]`.\n" +
+		"\n" +
+		"```\n" +
+		"if [[ -f x ]]; then echo [[Fenced]]; fi\n" +
+		"```\n" +
+		"\n" +
+		"    [[Indented Code]]\n"
+	result := convertLinksInText(inputText, "Second.md", newPageIndex(fileMap), testOptions)
+	require.Equal(strings.Replace(inputText, "[[First]]", "[First](../first/)", 1), result)
+	require.Equal(1, len(fileMap), "No pages should be created for code")
+
+	second := createMarkdownFile("Second.md", false, testOptions)
+	collectBacklinksForFile(newPageIndex(fileMap), second, []byte(inputText), testOptions)
+	require.Equal(1, len(fileMap), "No backlinks should be collected from code")
+	require.Equal(1, len(fileMap["first.md"].BackLinks))
+}
+
+func TestCollectBacklinksSkipsFrontmatter(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"First.md": "---\nrelated: \"[[Not A Link]]\"\n---\nA link to [[Second]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	fileMap, err := createFileMapping([]string{"First.md"}, testOptions)
+	require.Nil(err)
+	_, err = collectBacklinks(sourceDir, fileMap, newBuildCache(), testOptions)
+	require.Nil(err)
+	_, exists := fileMap["not a link.md"]
+	require.False(exists, "Frontmatter should not be searched for links")
+	_, exists = fileMap["second.md"]
+	require.True(exists, "Links in the body should still be found")
+}
+
 func Test_addBacklinks(t *testing.T) {
 	require := require.New(t)
 	type args struct {
@@ -232,21 +414,21 @@ func Test_addBacklinks(t *testing.T) {
 		fileMap map[string]*markdownFile
 	}
 	fileMap := make(map[string]*markdownFile)
-	fileMap["first.md"] = createMarkdownFile("First.md", false)
-	fileMap["second.md"] = createMarkdownFile("Second.md", false)
+	fileMap["first.md"] = createMarkdownFile("First.md", false, testOptions)
+	fileMap["second.md"] = createMarkdownFile("Second.md", false, testOptions)
 	fileMap["second.md"].Title = "Being The Second"
 	fileMap["first.md"].BackLinks = append(fileMap["first.md"].BackLinks, backlink{
 		OtherFile: fileMap["second.md"],
 		Context:   "This has a [[first]] link.",
 	})
 
-	fileMap["third.md"] = createMarkdownFile("Third.md", false)
-	fileMap["2020-04-21.md"] = createMarkdownFile("2020-04-21.md", false)
+	fileMap["third.md"] = createMarkdownFile("Third.md", false, testOptions)
+	fileMap["2020-04-21.md"] = createMarkdownFile("2020-04-21.md", false, testOptions)
 	frontmatterWriter := bytes.Buffer{}
-	err := adjustFrontmatter(fileMap["2020-04-21.md"], &frontmatterWriter)
+	err := adjustFrontmatter(fileMap["2020-04-21.md"], testOptions, &frontmatterWriter)
 	require.Nil(err, "Should not get an error when adjusting frontmatter")
-	fileMap["2020-04-24.md"] = createMarkdownFile("2020-04-24.md", false)
-	err = adjustFrontmatter(fileMap["2020-04-24.md"], &frontmatterWriter)
+	fileMap["2020-04-24.md"] = createMarkdownFile("2020-04-24.md", false, testOptions)
+	err = adjustFrontmatter(fileMap["2020-04-24.md"], testOptions, &frontmatterWriter)
 	require.Nil(err, "Should not get an error when adjusting frontmatter")
 
 	fileMap["third.md"].BackLinks = append(fileMap["third.md"].BackLinks, backlink{
@@ -307,7 +489,7 @@ func Test_addBacklinks(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			writer := &bytes.Buffer{}
-			err := addBacklinks(tt.args.file, tt.args.fileMap, writer)
+			err := addBacklinks(tt.args.file, newPageIndex(tt.args.fileMap), testOptions, writer)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("addBacklinks() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,203 @@
+package backlinker
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// blockRefAttribute marks the nodes in the goldmark AST that came from ((block-id))
+// references.
+const blockRefAttribute = "sharedbrain-blockref"
+
+// blockIDPlaceholder is replaced in Options.BlockAnchor with the anchor for the block.
+const blockIDPlaceholder = "{id}"
+
+var (
+	blockID = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	// blockMarker is the ^block-id at the end of the last line of a block.
+	blockMarker = regexp.MustCompile(`(^|[ \t]+)\^([A-Za-z0-9-]+)[ \t]*\r?\n?$`)
+)
+
+// blockAnchor is the HTML id given to the block with the id, which is also used as the
+// anchor of links to the block.
+func blockAnchor(id string) string {
+	return "block-" + id
+}
+
+// block is the id of the block that the wikilink points to, as in [[Page#^block-id]].
+func (wl wikilink) block() string {
+	if strings.HasPrefix(wl.Fragment, "^") {
+		return wl.Fragment[1:]
+	}
+	return ""
+}
+
+// blockRefParser finds ((block-id)) references to blocks.
+type blockRefParser struct{}
+
+// Trigger fulfills the goldmark InlineParser interface.
+func (brp blockRefParser) Trigger() []byte {
+	return []byte{'('}
+}
+
+// Parse turns a ((block-id)) into a link node with the id as its text, which is marked
+// so that findWikilinks can tell it apart from the others.
+func (brp blockRefParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("((")) {
+		return nil
+	}
+	end := bytes.Index(line, []byte("))"))
+	if end == -1 || !blockID.Match(line[2:end]) {
+		return nil
+	}
+	block.Advance(end + 2)
+	link := ast.NewLink()
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(segment.Start+2, segment.Start+end)))
+	link.SetAttributeString(blockRefAttribute, true)
+	return link
+}
+
+// blockRefSpan describes a ((block-id)) reference. Until resolveBlockRefs finds the page
+// that has the block, it has no target.
+func blockRefSpan(node ast.Node, idText *ast.Text, source []byte) wikilinkSpan {
+	id := string(idText.Segment.Value(source))
+	return wikilinkSpan{
+		Start:    idText.Segment.Start - 2,
+		Stop:     idText.Segment.Stop + 2,
+		Text:     id,
+		Context:  blockContext(node, source),
+		Link:     wikilink{Fragment: "^" + id, Display: "((" + id + "))"},
+		BlockRef: true,
+	}
+}
+
+// blockMarkerSpan looks for a ^block-id at the end of a paragraph, returning a span for
+// the marker if there is one. The Text of the span is the text of the block.
+func blockMarkerSpan(node ast.Node, source []byte) (wikilinkSpan, bool) {
+	lines := node.Lines()
+	if lines.Len() == 0 {
+		return wikilinkSpan{}, false
+	}
+	last := lines.At(lines.Len() - 1)
+	match := blockMarker.FindSubmatchIndex(last.Value(source))
+	if match == nil {
+		return wikilinkSpan{}, false
+	}
+	var blockText strings.Builder
+	for i := 0; i < lines.Len()-1; i++ {
+		segment := lines.At(i)
+		blockText.Write(segment.Value(source))
+	}
+	blockText.Write(last.Value(source)[:match[0]])
+	if strings.TrimSpace(blockText.String()) == "" {
+		return wikilinkSpan{}, false
+	}
+	stop := last.Start + match[1]
+	if source[stop-1] == '\n' {
+		stop--
+	}
+	return wikilinkSpan{
+		Start: last.Start + match[0],
+		Stop:  stop,
+		Text:  strings.TrimSpace(blockText.String()),
+		Block: string(source[last.Start+match[4] : last.Start+match[5]]),
+	}, true
+}
+
+// findBlock looks up the text of the block with the id on the page.
+func findBlock(file *markdownFile, id string) (string, bool) {
+	for _, span := range file.links {
+		if span.Block == id {
+			return span.Text, true
+		}
+	}
+	return "", false
+}
+
+// findBlockOwner finds the page that has the block with the id, the same way that
+// resolveBlockRefs does.
+func findBlockOwner(fileMap map[string]*markdownFile, id string) *markdownFile {
+	for _, file := range sourceFiles(fileMap) {
+		if _, found := findBlock(file, id); found {
+			return file
+		}
+	}
+	return nil
+}
+
+// resolveBlockRefs finds the page for every ((block-id)) reference, which can only be
+// done once all of the pages have been parsed. Block ids are supposed to be unique, but
+// if they aren't, the page that comes first by name wins.
+func resolveBlockRefs(fileMap map[string]*markdownFile) {
+	owners := make(map[string]*markdownFile)
+	files := sourceFiles(fileMap)
+	for _, file := range files {
+		for _, span := range file.links {
+			if span.Block == "" {
+				continue
+			}
+			if owner, exists := owners[span.Block]; exists {
+				log.Printf("Block ^%s is in both %s and %s\n", span.Block, owner.OriginalName, file.OriginalName)
+				continue
+			}
+			owners[span.Block] = file
+		}
+	}
+	for _, file := range files {
+		for i, span := range file.links {
+			if !span.BlockRef {
+				continue
+			}
+			file.links[i].Link.Target = ""
+			if owner, exists := owners[span.Text]; exists {
+				file.links[i].Link.Target = pageName(owner.OriginalName)
+			}
+		}
+	}
+}
+
+// blockQuote shows the text of the block that the wikilink refers to, followed by a link
+// to the block. It reports false if the link isn't to a block that can be shown.
+func blockQuote(span wikilinkSpan, from string, pages *pageIndex,
+	options *Options) (string, bool) {
+	id := span.Link.block()
+	if id == "" || span.Link.Target == "" || strings.Contains(span.Text, "|") {
+		return "", false
+	}
+	target, exists := findPage(pages, span.Link, from)
+	if !exists || target.IsNew || target.IsPrivate {
+		return "", false
+	}
+	blockText, found := findBlock(target, id)
+	if !found {
+		return "", false
+	}
+	link := span.Link
+	link.Display = target.Title
+	return fmt.Sprintf("“%s” (%s)", plainText(blockText), markdownLink(link, from, pages, options)), true
+}
+
+// plainText flattens the markdown of a block into a single line, with the wikilinks
+// replaced by the text they display.
+func plainText(markdown string) string {
+	source := []byte(markdown)
+	var result bytes.Buffer
+	last := 0
+	for _, span := range findWikilinks(parseMarkdown(source), source) {
+		result.Write(source[last:span.Start])
+		if span.Block == "" {
+			result.WriteString(span.Link.Display)
+		}
+		last = span.Stop
+	}
+	result.Write(source[last:])
+	return strings.Join(strings.Fields(result.String()), " ")
+}
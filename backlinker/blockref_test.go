@@ -0,0 +1,70 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBlocks(t *testing.T) {
+	require := require.New(t)
+	source := []byte("A paragraph\nwith a [[Link]] ^para-1\n\n* A bullet ^bullet\n* See ((para-1)) and `((code))`\n\n" +
+		"Not an id: 2 ^ 3\n")
+	spans := findWikilinks(parseMarkdown(source), source)
+	require.Len(spans, 4)
+	require.Equal("Link", spans[0].Link.Target)
+	require.Equal("para-1", spans[1].Block)
+	require.Equal("A paragraph\nwith a [[Link]]", spans[1].Text)
+	require.Equal(" ^para-1", string(source[spans[1].Start:spans[1].Stop]))
+	require.Equal("bullet", spans[2].Block)
+	require.Equal("A bullet", spans[2].Text)
+	require.True(spans[3].BlockRef)
+	require.Equal("((para-1))", string(source[spans[3].Start:spans[3].Stop]))
+	require.Equal("para-1", spans[3].Link.block())
+}
+
+func TestBlockReferences(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Ideas.md":  "* Ship it on [[Friday]] ^ship\n* Something else\n",
+		"Home.md":   "Remember ((ship))\n\nAlso [[Ideas#^ship]] and [[Ideas#^ship|that idea]]\n\nBut not ((nope))\n",
+		"Friday.md": "The day\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	home, err := ioutil.ReadFile(filepath.Join(destDir, "Home.md"))
+	require.Nil(err)
+	require.Contains(string(home), "Remember “Ship it on Friday” ([Ideas](../ideas/#block-ship))\n")
+	require.Contains(string(home), "Also “Ship it on Friday” ([Ideas](../ideas/#block-ship)) and "+
+		"[that idea](../ideas/#block-ship)\n")
+	require.Contains(string(home), "But not ((nope))\n")
+	_, err = os.Stat(filepath.Join(destDir, "nope.md"))
+	require.True(os.IsNotExist(err), "References to missing blocks don't create pages")
+
+	ideas, err := ioutil.ReadFile(filepath.Join(destDir, "Ideas.md"))
+	require.Nil(err)
+	require.Contains(string(ideas), `* Ship it on [Friday](../friday/) <span id="block-ship"></span>`+"\n")
+	require.Contains(string(ideas), "* [Home](../home/)\n    * Remember “Ship it on Friday” ([Ideas](../ideas/#block-ship))")
+
+	fileMap, err := createFileMapping([]string{"Ideas.md", "Home.md", "Friday.md"}, options)
+	require.Nil(err)
+	_, err = collectBacklinks(sourceDir, fileMap, newBuildCache(), options)
+	require.Nil(err)
+	for _, bl := range fileMap["ideas.md"].BackLinks {
+		require.Equal("ship", bl.Block)
+		require.Equal("", bl.Section)
+	}
+
+	report, err := Check(options)
+	require.Nil(err)
+	require.Len(report.Problems, 1)
+	require.Equal("reference to block ^nope, which does not exist", report.Problems[0].Message)
+	require.Equal(5, report.Problems[0].Line)
+}
@@ -0,0 +1,139 @@
+package backlinker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheFile is the name of the build cache in the destination directory. It starts
+// with a dot so that Hugo doesn't treat it as content.
+const CacheFile = ".sharedbrain-cache"
+
+// cacheVersion needs to change whenever the way files are parsed changes, so that
+// caches from older versions are ignored.
+const cacheVersion = 5
+
+func init() {
+	// These are the types that can show up in the metadata, which gob needs to know
+	// about to encode them in an interface{}.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(time.Time{})
+}
+
+// cacheEntry is everything learned from parsing a single source file.
+type cacheEntry struct {
+	Hash              string
+	Metadata          map[string]interface{}
+	FrontmatterFormat string
+	BodyLine          int
+	Links             []wikilinkSpan
+}
+
+// buildCache remembers what was parsed from each source file in the previous build, keyed
+// by the file's OriginalName. A file whose contents have the same hash doesn't need to be
+// parsed again.
+type buildCache struct {
+	Version int
+	Files   map[string]*cacheEntry
+
+	// previous holds the entries from the last build, which are moved into Files as
+	// they are used. Files that have been deleted are left behind.
+	previous map[string]*cacheEntry
+}
+
+// newBuildCache creates an empty cache.
+func newBuildCache() *buildCache {
+	return &buildCache{
+		Version:  cacheVersion,
+		Files:    make(map[string]*cacheEntry),
+		previous: make(map[string]*cacheEntry),
+	}
+}
+
+// loadBuildCache reads the cache from the last build in destDir. A missing or unreadable
+// cache is the same as an empty one, since everything can be rebuilt.
+func loadBuildCache(destDir string) *buildCache {
+	cache := newBuildCache()
+	data, err := ioutil.ReadFile(filepath.Join(destDir, CacheFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read the build cache, rebuilding everything: %v\n", err)
+		}
+		return cache
+	}
+	var previous buildCache
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&previous)
+	if err != nil {
+		log.Printf("Unable to read the build cache, rebuilding everything: %v\n", err)
+		return cache
+	}
+	if previous.Version != cacheVersion {
+		return cache
+	}
+	cache.previous = previous.Files
+	return cache
+}
+
+// contentHash is the hash used to tell whether a file has changed.
+func contentHash(filetext []byte) string {
+	sum := sha256.Sum256(filetext)
+	return hex.EncodeToString(sum[:])
+}
+
+// restore fills in the file from the cache, if the cache has an entry for it with the
+// same hash. It reports whether the file was restored.
+func (cache *buildCache) restore(file *markdownFile, hash string) bool {
+	entry, exists := cache.previous[file.OriginalName]
+	if !exists || entry.Hash != hash {
+		return false
+	}
+	if entry.Metadata == nil {
+		entry.Metadata = make(map[string]interface{})
+	}
+	file.metadata = entry.Metadata
+	file.frontmatterFormat = entry.FrontmatterFormat
+	file.bodyLine = entry.BodyLine
+	file.links = entry.Links
+	cache.Files[file.OriginalName] = entry
+	return true
+}
+
+// store adds the freshly parsed file to the cache.
+func (cache *buildCache) store(file *markdownFile, hash string) {
+	cache.Files[file.OriginalName] = &cacheEntry{
+		Hash:              hash,
+		Metadata:          file.metadata,
+		FrontmatterFormat: file.frontmatterFormat,
+		BodyLine:          file.bodyLine,
+		Links:             file.links,
+	}
+}
+
+// encode turns the cache into bytes. This is done as soon as all of the files have been
+// parsed, because the metadata is changed as the new files are generated.
+func (cache *buildCache) encode() ([]byte, error) {
+	var data bytes.Buffer
+	err := gob.NewEncoder(&data).Encode(cache)
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// saveBuildCache writes the encoded cache to destDir.
+func saveBuildCache(destDir string, data []byte) error {
+	filename, err := destPath(destDir, CacheFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
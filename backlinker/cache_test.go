@@ -0,0 +1,121 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCacheRoundTrip(t *testing.T) {
+	require := require.New(t)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	timestamp, _ := time.Parse(time.RFC3339, "2020-04-25T19:00:00Z")
+	file := createMarkdownFile("projects/Phoenix.md", false, testOptions)
+	file.metadata["date"] = timestamp
+	file.metadata["params"] = map[string]interface{}{"nested": []interface{}{int64(1), "two"}}
+	file.frontmatterFormat = FrontmatterYAML
+	file.bodyLine = 4
+	parseBody(file, []byte("A link to [[Someone|them]]\n"))
+
+	cache := newBuildCache()
+	cache.store(file, contentHash([]byte("contents")))
+	data, err := cache.encode()
+	require.Nil(err)
+	require.Nil(saveBuildCache(destDir, data))
+
+	loaded := loadBuildCache(destDir)
+	restored := createMarkdownFile("projects/Phoenix.md", false, testOptions)
+	require.False(loaded.restore(restored, contentHash([]byte("changed"))), "Changed files aren't restored")
+	require.True(loaded.restore(restored, contentHash([]byte("contents"))))
+	require.Equal(file.metadata, restored.metadata)
+	require.Equal(FrontmatterYAML, restored.frontmatterFormat)
+	require.Equal(4, restored.bodyLine)
+	require.Equal(file.links, restored.links)
+	require.Equal("Someone", restored.links[0].Link.Target)
+
+	require.Nil(ioutil.WriteFile(filepath.Join(destDir, CacheFile), []byte("garbage"), 0644))
+	require.False(loadBuildCache(destDir).restore(restored, contentHash([]byte("contents"))),
+		"A broken cache is ignored")
+}
+
+// modTimes collects the modification times of every file in dir.
+func modTimes(t *testing.T, dir string) map[string]time.Time {
+	result := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			result[filename] = info.ModTime()
+		}
+		return err
+	})
+	require.Nil(t, err)
+	return result
+}
+
+// backdate sets the modification time of every file in dir to long ago, so that
+// rewritten files can be spotted.
+func backdate(t *testing.T, dir string) {
+	longAgo := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for filename := range modTimes(t, dir) {
+		require.Nil(t, os.Chtimes(filename, longAgo, longAgo))
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"2020-04-25.md":       "Worked on [[Phoenix]]\n",
+		"projects/Phoenix.md": "+++\ntitle = \"Phoenix\"\nlastmod = 2020-04-26\n[params]\nteam = [\"a\", \"b\"]\n+++\nThe project\n",
+		"Unrelated.md":        "Nothing to see here\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	phoenixOutput := filepath.Join(destDir, "projects", "Phoenix.md")
+	firstBuild, err := ioutil.ReadFile(phoenixOutput)
+	require.Nil(err)
+
+	backdate(t, destDir)
+	before := modTimes(t, destDir)
+	require.Nil(ProcessBackLinks(options))
+	after := modTimes(t, destDir)
+	for filename, modTime := range before {
+		if filepath.Base(filename) != CacheFile && filepath.Base(filename) != ManifestFile {
+			require.Equal(modTime, after[filename], "%s should not have been rewritten", filename)
+		}
+	}
+	secondBuild, err := ioutil.ReadFile(phoenixOutput)
+	require.Nil(err)
+	require.Equal(string(firstBuild), string(secondBuild), "Cached builds should match full builds")
+
+	// A new link from a daily note changes the daily note and the page it links to,
+	// but nothing else
+	backdate(t, destDir)
+	before = modTimes(t, destDir)
+	require.Nil(ioutil.WriteFile(filepath.Join(sourceDir, "2020-04-25.md"),
+		[]byte("Worked on [[Phoenix]] with [[Someone]]\n"), 0644))
+	require.Nil(ProcessBackLinks(options))
+	after = modTimes(t, destDir)
+	require.NotEqual(before[filepath.Join(destDir, "2020-04-25.md")], after[filepath.Join(destDir, "2020-04-25.md")])
+	require.NotEqual(before[phoenixOutput], after[phoenixOutput], "The backlink context changed")
+	require.Equal(before[filepath.Join(destDir, "Unrelated.md")], after[filepath.Join(destDir, "Unrelated.md")])
+	_, exists := after[filepath.Join(destDir, "Someone.md")]
+	require.True(exists, "The new page should be written")
+
+	// The same output comes from a build without the cache
+	cached, err := ioutil.ReadFile(phoenixOutput)
+	require.Nil(err)
+	options.Cache = false
+	require.Nil(ProcessBackLinks(options))
+	uncached, err := ioutil.ReadFile(phoenixOutput)
+	require.Nil(err)
+	require.Equal(string(uncached), string(cached))
+}
@@ -0,0 +1,272 @@
+package backlinker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// These are the rules that Check looks at. They are also the names of the settings
+// (prefixed with check_) that give the severity of each rule.
+const (
+	RuleBrokenLinks    = "broken_links"
+	RuleOrphans        = "orphans"
+	RuleNearDuplicates = "near_duplicates"
+)
+
+// These are the severities that can be given to a rule. Errors make the check fail,
+// warnings are only reported and ignored rules aren't checked at all.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityIgnore  = "ignore"
+)
+
+// validSeverity reports whether the severity is one of the known ones.
+func validSeverity(severity string) bool {
+	return severity == SeverityError || severity == SeverityWarning || severity == SeverityIgnore
+}
+
+// Problem is something that Check found wrong with the notes.
+type Problem struct {
+	Rule     string
+	Severity string
+	// File is the source file with the problem, relative to the content directory.
+	File string
+	// Line is the line in File, or 0 if the problem is with the file as a whole.
+	Line    int
+	Message string
+}
+
+// String formats the problem the way compilers do, so that editors can jump to it.
+func (problem Problem) String() string {
+	location := problem.File
+	if problem.Line > 0 {
+		location = fmt.Sprintf("%s:%d", problem.File, problem.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", location, problem.Severity, problem.Message, problem.Rule)
+}
+
+// CheckReport lists the problems that were found, ordered by file and line.
+type CheckReport struct {
+	Problems []Problem
+}
+
+// Failed reports whether any of the problems is an error.
+func (report *CheckReport) Failed() bool {
+	for _, problem := range report.Problems {
+		if problem.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// add records a problem with the severity configured for its rule.
+func (report *CheckReport) add(severity string, problem Problem) {
+	if severity == SeverityIgnore {
+		return
+	}
+	problem.Severity = severity
+	report.Problems = append(report.Problems, problem)
+}
+
+// Check reads the notes the same way that ProcessBackLinks does (see readNotes), and then
+// reports the problems that it finds with the links between the notes. Every note is
+// checked, including the private ones. Nothing is written, not even the build cache.
+func Check(options *Options) (*CheckReport, error) {
+	err := options.validateReading()
+	if err != nil {
+		return nil, err
+	}
+	pages, _, err := readNotes(options)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{}
+	checkBrokenLinks(report, pages, options)
+	checkOrphans(report, pages.files, options)
+	checkNearDuplicates(report, pages.files, options)
+	sort.Slice(report.Problems, func(i, j int) bool {
+		p1 := report.Problems[i]
+		p2 := report.Problems[j]
+		if p1.File != p2.File {
+			return p1.File < p2.File
+		}
+		if p1.Line != p2.Line {
+			return p1.Line < p2.Line
+		}
+		return p1.Message < p2.Message
+	})
+	return report, nil
+}
+
+// checkBrokenLinks reports the links to pages that don't have a source file, and to
+// blocks that can't be found, along with the embeds which a build would show as links.
+func checkBrokenLinks(report *CheckReport, pages *pageIndex, options *Options) {
+	for _, file := range sourceFiles(pages.files) {
+		for _, span := range file.links {
+			message := brokenLinkMessage(span, file.OriginalName, pages, options)
+			if message == "" && span.Embed {
+				message = embedMessage(span, file.OriginalName, pages, options)
+			}
+			if message == "" {
+				continue
+			}
+			report.add(options.CheckBrokenLinks, Problem{
+				Rule:    RuleBrokenLinks,
+				File:    file.OriginalName,
+				Line:    span.Line,
+				Message: message,
+			})
+		}
+	}
+}
+
+// brokenLinkMessage describes what is wrong with the link from the page called from, or is
+// empty if it's fine.
+func brokenLinkMessage(span wikilinkSpan, from string, pages *pageIndex, options *Options) string {
+	if span.BlockRef && span.Link.Target == "" {
+		return fmt.Sprintf("reference to block ^%s, which does not exist", span.Text)
+	}
+	if span.Link.Target == "" || (span.Hashtag && !options.hashtagAsWikilink(span)) {
+		// Pages for tags are expected to be created for them
+		return ""
+	}
+	target, exists := findPage(pages, span.Link, from)
+	if !exists || target.IsNew {
+		return fmt.Sprintf("link to %q, which does not exist", span.Link.Target)
+	}
+	if _, others := pages.lookup(span.Link, from); len(others) > 0 {
+		return fmt.Sprintf("link to %q could be %s (%s is used)", span.Link.Target,
+			fileNames(append([]*markdownFile{target}, others...)), target.OriginalName)
+	}
+	if id := span.Link.block(); id != "" {
+		if _, found := findBlock(target, id); !found {
+			return fmt.Sprintf("link to block ^%s in %q, which does not exist", id, span.Link.Target)
+		}
+	}
+	return ""
+}
+
+// embedMessage describes why the embed, or one of the embeds in what it embeds, would be
+// shown as a link (see embedPage), or is empty if nothing is wrong with it.
+func embedMessage(span wikilinkSpan, from string, pages *pageIndex, options *Options) string {
+	messages := make([]string, 0)
+	embedPage(span.Link, from, pages, options, []string{from}, func(message string) {
+		messages = append(messages, message)
+	})
+	return strings.Join(messages, "; ")
+}
+
+// checkOrphans reports the notes which don't link anywhere and which nothing links to.
+func checkOrphans(report *CheckReport, fileMap map[string]*markdownFile, options *Options) {
+	for _, file := range sourceFiles(fileMap) {
+		if len(file.BackLinks) > 0 {
+			continue
+		}
+		linksOut := false
+		for _, span := range file.links {
+			if span.Link.Target != "" {
+				linksOut = true
+				break
+			}
+		}
+		if !linksOut {
+			report.add(options.CheckOrphans, Problem{
+				Rule:    RuleOrphans,
+				File:    file.OriginalName,
+				Message: "no links to or from this note",
+			})
+		}
+	}
+}
+
+// checkNearDuplicates reports pages (including the ones that are only linked to) whose
+// names differ only by case, whitespace, punctuation or plurals.
+func checkNearDuplicates(report *CheckReport, fileMap map[string]*markdownFile, options *Options) {
+	groups := make(map[string][]*markdownFile)
+	for _, file := range fileMap {
+		key := nearDuplicateKey(pageName(file.OriginalName))
+		groups[key] = append(groups[key], file)
+	}
+	for _, group := range groups {
+		if len(group) < 2 || sameNames(group) {
+			// Pages with the same name in different folders are fine, and links which
+			// could mean more than one of them are reported by checkBrokenLinks
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].IsNew != group[j].IsNew {
+				return !group[i].IsNew
+			}
+			return group[i].OriginalName < group[j].OriginalName
+		})
+		names := make([]string, len(group))
+		for i, file := range group {
+			if file.IsNew {
+				names[i] = fmt.Sprintf("%q (linked from %s)", file.Title, linkedFrom(file))
+			} else {
+				names[i] = fmt.Sprintf("%q (%s)", pageName(file.OriginalName), file.OriginalName)
+			}
+		}
+		file := group[0].OriginalName
+		if group[0].IsNew {
+			file = group[0].BackLinks[0].OtherFile.OriginalName
+		}
+		report.add(options.CheckNearDuplicates, Problem{
+			Rule:    RuleNearDuplicates,
+			File:    file,
+			Message: "page names are nearly the same: " + strings.Join(names, ", "),
+		})
+	}
+}
+
+// linkedFrom lists the names of the files that link to the page.
+func linkedFrom(file *markdownFile) string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(file.BackLinks))
+	for _, bl := range file.BackLinks {
+		if !seen[bl.OtherFile.OriginalName] {
+			seen[bl.OtherFile.OriginalName] = true
+			names = append(names, bl.OtherFile.OriginalName)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// sameNames reports whether the pages all have exactly the same name.
+func sameNames(group []*markdownFile) bool {
+	for _, file := range group[1:] {
+		if pageName(file.OriginalName) != pageName(group[0].OriginalName) {
+			return false
+		}
+	}
+	return true
+}
+
+// nearDuplicateKey reduces a page name to the letters and digits in it, lower cased and
+// with a simple English plural removed, so that names like "Project Ideas",
+// "project-idea" and "ProjectIdeas" all end up the same.
+func nearDuplicateKey(name string) string {
+	var key strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(unicode.ToLower(r))
+		}
+	}
+	result := key.String()
+	switch {
+	case strings.HasSuffix(result, "ies") && len(result) > 4:
+		return strings.TrimSuffix(result, "ies") + "y"
+	case strings.HasSuffix(result, "sses"), strings.HasSuffix(result, "shes"),
+		strings.HasSuffix(result, "ches"), strings.HasSuffix(result, "xes"):
+		return strings.TrimSuffix(result, "es")
+	case strings.HasSuffix(result, "s") && !strings.HasSuffix(result, "ss") && len(result) > 3:
+		return strings.TrimSuffix(result, "s")
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md":             "+++\ntitle = \"Home\"\n+++\nSee [[Phoenix]]\n\nand [[Phoenx]]\n`[[Not a link]]`\n",
+		"projects/Phoenix.md": "The project, with [[Project Ideas]]\n",
+		"Project-Idea.md":     "Link back to [[Home]]\n",
+		"Lonely.md":           "Nobody links here\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	report, err := Check(options)
+	require.Nil(err)
+	require.Equal([]Problem{
+		{RuleBrokenLinks, SeverityError, "Home.md", 6, `link to "Phoenx", which does not exist`},
+		{RuleOrphans, SeverityWarning, "Lonely.md", 0, "no links to or from this note"},
+		{RuleNearDuplicates, SeverityWarning, "Project-Idea.md", 0,
+			`page names are nearly the same: "Project-Idea" (Project-Idea.md), ` +
+				`"Project Ideas" (linked from projects/Phoenix.md)`},
+		{RuleBrokenLinks, SeverityError, "projects/Phoenix.md", 1, `link to "Project Ideas", which does not exist`},
+	}, report.Problems)
+	require.True(report.Failed())
+	require.Equal(`Home.md:6: error: link to "Phoenx", which does not exist (broken_links)`,
+		report.Problems[0].String())
+
+	options.CheckBrokenLinks = SeverityWarning
+	options.CheckNearDuplicates = SeverityIgnore
+	report, err = Check(options)
+	require.Nil(err)
+	require.Len(report.Problems, 3)
+	require.False(report.Failed())
+
+	entries, err := ioutil.ReadDir(destDir)
+	require.Nil(err)
+	require.Empty(entries, "Nothing is written by Check")
+	_, err = os.Stat(filepath.Join(sourceDir, "Phoenx.md"))
+	require.True(os.IsNotExist(err))
+}
+
+func TestCheckWithoutDest(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md": "See [[Phoenix]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	options := testBuildOptions(sourceDir, "")
+	options.Cache = true
+	options.LocalGraphs = "graphs"
+	options.LocalGraphMaxNodes = 0
+	require.NotNil(options.Validate(), "A build needs these settings")
+
+	report, err := Check(options)
+	require.Nil(err)
+	require.Equal([]Problem{
+		{RuleBrokenLinks, SeverityError, "Home.md", 1, `link to "Phoenix", which does not exist`},
+	}, report.Problems)
+
+	options.Content = ""
+	_, err = Check(options)
+	require.NotNil(err)
+}
+
+func TestNearDuplicateKey(t *testing.T) {
+	require := require.New(t)
+	require.Equal("projectidea", nearDuplicateKey("Project Ideas"))
+	require.Equal("projectidea", nearDuplicateKey("project-idea"))
+	require.Equal("story", nearDuplicateKey("Stories"))
+	require.Equal("box", nearDuplicateKey("boxes"))
+	require.Equal("class", nearDuplicateKey("Class"))
+	require.Equal("bus", nearDuplicateKey("bus"))
+}
+
+func TestInvalidCheckSeverity(t *testing.T) {
+	options := DefaultOptions()
+	options.Content = "content"
+	options.Dest = "dest"
+	options.CheckOrphans = "fatal"
+	require.NotNil(t, options.Validate())
+}
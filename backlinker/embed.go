@@ -0,0 +1,164 @@
+package backlinker
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// expandLinks is like replaceLinks, except that embeds are replaced with the body of the
+// page that they embed (or the section of it, for ![[Page#Section]]). The embedded
+// markdown has its own links converted and its own embeds expanded, with links that are
+// relative to the page `from`, since that's where they will appear.
+// chain lists the pages that are being embedded, starting with `from`, in order to catch
+// pages which end up embedding themselves. Embeds that would do that, or that are nested
+// too deeply, are shown as ordinary links, and problem is called with what's wrong.
+func expandLinks(source []byte, spans []wikilinkSpan, from string, pages *pageIndex,
+	options *Options, chain []string, problem func(message string)) []byte {
+	var result bytes.Buffer
+	last := 0
+	for _, span := range spans {
+		result.Write(source[last:span.Start])
+		last = span.Stop
+		if span.Block != "" {
+			// Only the page that has the block gets the anchor, not the pages embedding it
+			if len(chain) == 1 {
+				result.WriteString(" " + options.formatBlockAnchor(blockAnchor(span.Block)))
+			}
+			continue
+		}
+		if !span.Embed {
+			result.WriteString(spanMarkdown(span, from, pages, options))
+			continue
+		}
+		result.Write(embedPage(span.Link, from, pages, options, chain, problem))
+	}
+	result.Write(source[last:])
+	return result.Bytes()
+}
+
+// embedPage finds the markdown to show in place of an embed. Pages that can't be
+// embedded (because they don't exist, are private, or would be embedded in themselves or
+// too deeply) get an ordinary link instead.
+func embedPage(link wikilink, from string, pages *pageIndex, options *Options,
+	chain []string, problem func(message string)) []byte {
+	if link.Target == "" {
+		return []byte(markdownLink(link, from, pages, options))
+	}
+	target, exists := findPage(pages, link, from)
+	if !exists || target.IsNew || target.IsPrivate {
+		return []byte(markdownLink(link, from, pages, options))
+	}
+	if message := embedChainProblem(chain, target, options); message != "" {
+		problem(message)
+		return []byte(markdownLink(link, from, pages, options))
+	}
+	// A new slice, so that embeds next to each other don't share one
+	embedChain := append(append([]string{}, chain...), target.OriginalName)
+
+	source, spans := withoutBacklinksSection(target.body, target.links, options.BacklinksHeading)
+	if id := link.block(); id != "" {
+		blockText, found := findBlock(target, id)
+		if !found {
+			log.Printf("%s embeds %s#^%s, which doesn't have that block\n", from, target.OriginalName, id)
+			return []byte(markdownLink(link, from, pages, options))
+		}
+		source = []byte(blockText)
+		spans = findWikilinks(parseMarkdown(source), source)
+	} else if link.Fragment != "" {
+		var found bool
+		source, spans, found = pageSection(source, spans, link.anchor())
+		if !found {
+			log.Printf("%s embeds %s#%s, which doesn't have that section\n",
+				from, target.OriginalName, link.Fragment)
+			return []byte(markdownLink(link, from, pages, options))
+		}
+	}
+	embedded := expandLinks(source, spans, from, pages, options, embedChain, problem)
+	return bytes.TrimRight(embedded, "\n")
+}
+
+// embedChainProblem describes why the target can't be embedded at the end of the chain
+// of embeds, or is empty if it can be.
+func embedChainProblem(chain []string, target *markdownFile, options *Options) string {
+	for _, name := range chain {
+		if name == target.OriginalName {
+			return fmt.Sprintf("embeds form a cycle: %s -> %s",
+				strings.Join(chain, " -> "), target.OriginalName)
+		}
+	}
+	if len(chain) > options.EmbedDepth {
+		return fmt.Sprintf("embeds are nested more than %d deep: %s -> %s",
+			options.EmbedDepth, strings.Join(chain, " -> "), target.OriginalName)
+	}
+	return ""
+}
+
+// logEmbedProblem is the problem function for expandLinks during a build.
+func logEmbedProblem(message string) {
+	log.Printf("%s, so a link is shown instead\n", message)
+}
+
+// withoutBacklinksSection cuts off a backlinks section at the end of a page, for the
+// notes that were written with one already in them.
+func withoutBacklinksSection(source []byte, spans []wikilinkSpan, heading string) ([]byte, []wikilinkSpan) {
+	end := -1
+	marker := []byte(heading + "\n")
+	if bytes.HasPrefix(source, marker) {
+		end = 0
+	} else if index := bytes.Index(source, append([]byte("\n"), marker...)); index != -1 {
+		end = index + 1
+	}
+	if heading == "" || end == -1 {
+		return source, spans
+	}
+	return sliceSpans(source, spans, 0, end)
+}
+
+// pageSection finds the section of the page that starts with the heading that has the
+// given anchor. The section includes the heading itself, and goes until the next heading
+// at the same or a higher level.
+func pageSection(source []byte, spans []wikilinkSpan, anchor string) ([]byte, []wikilinkSpan, bool) {
+	start := -1
+	end := len(source)
+	level := 0
+	root := parseMarkdown(source)
+	for node := root.FirstChild(); node != nil; node = node.NextSibling() {
+		heading, isHeading := node.(*ast.Heading)
+		if !isHeading || heading.Lines().Len() == 0 {
+			continue
+		}
+		lineStart := bytes.LastIndexByte(source[:heading.Lines().At(0).Start], '\n') + 1
+		if start == -1 {
+			if headingID(string(heading.Text(source))) == anchor {
+				start = lineStart
+				level = heading.Level
+			}
+		} else if heading.Level <= level {
+			end = lineStart
+			break
+		}
+	}
+	if start == -1 {
+		return nil, nil, false
+	}
+	section, sectionSpans := sliceSpans(source, spans, start, end)
+	return section, sectionSpans, true
+}
+
+// sliceSpans cuts out part of the source, along with the spans inside of it (with their
+// offsets adjusted to match).
+func sliceSpans(source []byte, spans []wikilinkSpan, start int, end int) ([]byte, []wikilinkSpan) {
+	result := make([]wikilinkSpan, 0, len(spans))
+	for _, span := range spans {
+		if span.Start >= start && span.Stop <= end {
+			span.Start -= start
+			span.Stop -= start
+			result = append(result, span)
+		}
+	}
+	return source[start:end], result
+}
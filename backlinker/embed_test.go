@@ -0,0 +1,94 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindEmbeds(t *testing.T) {
+	require := require.New(t)
+	source := []byte("Look: ![[Page#Part]] and ![image](x.png) and [[Link]]\n")
+	spans := findWikilinks(parseMarkdown(source), source)
+	require.Len(spans, 2)
+	require.True(spans[0].Embed)
+	require.Equal("![[Page#Part]]", string(source[spans[0].Start:spans[0].Stop]))
+	require.Equal("Part", spans[0].Link.Fragment)
+	require.False(spans[1].Embed)
+}
+
+func TestEmbeds(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md":   "Intro\n\n![[Recipe]]\n\n![[Phoenix#Status Report]]\n\n![[Missing]]\n",
+		"Recipe.md": "+++\ntitle = \"Recipe\"\n+++\nMix with [[Flour]]\n",
+		"projects/Phoenix.md": "# Phoenix\n\n## Status Report\n\nGoing well, see [[Recipe]]\n\n" +
+			"### Details\n\nMore\n\n## Budget\n\nSecret numbers\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	require.Nil(ProcessBackLinks(testBuildOptions(sourceDir, destDir)))
+	home, err := ioutil.ReadFile(filepath.Join(destDir, "Home.md"))
+	require.Nil(err)
+	require.Contains(string(home), "Intro\n\nMix with [Flour](../flour/)\n\n"+
+		"## Status Report\n\nGoing well, see [Recipe](../recipe/)\n\n### Details\n\nMore\n\n"+
+		"[Missing](../missing/)\n")
+	require.NotContains(string(home), "Secret numbers")
+	require.NotContains(string(home), `title = "Recipe"`)
+
+	recipe, err := ioutil.ReadFile(filepath.Join(destDir, "Recipe.md"))
+	require.Nil(err)
+	require.Contains(string(recipe), "* [Home](../home/)\n    * [Recipe](../recipe/)")
+
+	fileMap := map[string]*markdownFile{}
+	home2 := createMarkdownFile("Home.md", false, testOptions)
+	parseBody(home2, []byte("![[Recipe]]\n"))
+	fileMap["home.md"] = home2
+	recordBacklinks(newPageIndex(fileMap), home2, testOptions)
+	require.Equal(embedBacklink, fileMap["recipe.md"].BackLinks[0].Kind)
+}
+
+func TestEmbedCycles(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"A.md": "![[B]]\n",
+		"B.md": "![[C]]\n",
+		"C.md": "![[A]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options), "A cycle doesn't stop the build")
+	a, err := ioutil.ReadFile(filepath.Join(destDir, "A.md"))
+	require.Nil(err)
+	require.Contains(string(a), "+++\n[A](../a/)\n", "The embed that closes the cycle is a link")
+	report, err := Check(options)
+	require.Nil(err)
+	require.Len(report.Problems, 3)
+	require.Equal(Problem{RuleBrokenLinks, SeverityError, "A.md", 1,
+		"embeds form a cycle: A.md -> B.md -> C.md -> A.md"}, report.Problems[0])
+
+	require.Nil(ioutil.WriteFile(filepath.Join(sourceDir, "C.md"), []byte("The end\n"), 0644))
+	options.EmbedDepth = 1
+	require.Nil(ProcessBackLinks(options))
+	a, err = ioutil.ReadFile(filepath.Join(destDir, "A.md"))
+	require.Nil(err)
+	require.Contains(string(a), "+++\n[C](../c/)\n", "Embeds that are too deep are links")
+	report, err = Check(options)
+	require.Nil(err)
+	require.Equal([]Problem{{RuleBrokenLinks, SeverityError, "A.md", 1,
+		"embeds are nested more than 1 deep: A.md -> B.md -> C.md"}}, report.Problems)
+
+	options.EmbedDepth = 2
+	require.Nil(ProcessBackLinks(options))
+	a, err = ioutil.ReadFile(filepath.Join(destDir, "A.md"))
+	require.Nil(err)
+	require.Contains(string(a), "+++\nThe end\n")
+}
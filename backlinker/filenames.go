@@ -0,0 +1,211 @@
+package backlinker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPageNameLength keeps generated filenames (plus the .md extension) comfortably under
+// the 255 byte limit of common filesystems.
+const maxPageNameLength = 200
+
+// unsafeFilenameChars are replaced in the names of generated pages. These are the path
+// separators plus the characters that Windows doesn't allow in filenames.
+const unsafeFilenameChars = `/\:*?"<>|`
+
+// reservedFilenames are device names on Windows, which can't be used as filenames
+// no matter what extension they have.
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizePageName turns the text of a link into a name that is safe to use for a
+// generated page, since anyone who can edit a note controls that text. The policy is:
+//   - path segments are joined with hyphens, dropping empty, "." and ".." segments,
+//     so the page always lands directly in the destination directory
+//   - the remaining unsafe characters become hyphens and control characters
+//     (including NUL) are dropped
+//   - leading and trailing dots and spaces are trimmed, so the page can't be hidden
+//   - Windows device names get a leading underscore
+//   - the name is truncated to maxPageNameLength bytes
+//   - a name with nothing left is "untitled"
+func sanitizePageName(name string) string {
+	segments := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	kept := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		trimmed := strings.TrimSpace(segment)
+		if trimmed == "" || trimmed == "." || trimmed == ".." {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+
+	var result strings.Builder
+	for _, r := range strings.Join(kept, "-") {
+		switch {
+		case r == utf8.RuneError || unicode.IsControl(r):
+			continue
+		case strings.ContainsRune(unsafeFilenameChars, r):
+			result.WriteRune('-')
+		default:
+			result.WriteRune(r)
+		}
+	}
+	sanitized := strings.Trim(result.String(), ". ")
+
+	if len(sanitized) > maxPageNameLength {
+		cut := maxPageNameLength
+		for cut > 0 && !utf8.RuneStart(sanitized[cut]) {
+			cut--
+		}
+		sanitized = strings.TrimRight(sanitized[:cut], ". ")
+	}
+	if reservedFilenames[strings.ToUpper(sanitized)] {
+		sanitized = "_" + sanitized
+	}
+	if sanitized == "" {
+		sanitized = "untitled"
+	}
+	return sanitized
+}
+
+// urlize makes the part of a URL path for a page name, exactly the way that Hugo's
+// urlize does for the paths of pages: letters, digits, marks and a few punctuation
+// characters (._~+@#-) are kept, runs of spaces become a single hyphen unless there
+// is one already, and everything else is dropped. Hugo then lower cases the path.
+// A # would start a fragment in a link, so it's escaped.
+func urlize(name string) string {
+	var result strings.Builder
+	prependHyphen := false
+	wasHyphen := false
+	for i, r := range name {
+		isAllowed := r == '.' || r == '_' || r == '#' || r == '+' || r == '~' || r == '-' || r == '@' ||
+			unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) ||
+			(r == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]))
+		if isAllowed {
+			wasHyphen = r == '-'
+			if prependHyphen {
+				if !wasHyphen {
+					result.WriteRune('-')
+				}
+				prependHyphen = false
+			}
+			result.WriteRune(r)
+		} else if result.Len() > 0 && !wasHyphen && unicode.IsSpace(r) {
+			prependHyphen = true
+		}
+	}
+	return strings.ReplaceAll(strings.ToLower(result.String()), "#", "%23")
+}
+
+// isHex reports whether the byte is a hexadecimal digit.
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// destPath finds where a file should be written in the destination directory, and makes
+// sure that it really is inside of the destination. This guards against names with ".."
+// in them as well as symlinks which point outside of the destination.
+// The directory that will hold the file is created if necessary.
+func destPath(destDir string, name string) (string, error) {
+	filename, err := joinDest(destDir, name)
+	if err != nil {
+		return "", err
+	}
+	err = makeParentWithin(destDir, filename)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Lstat(filename)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("refusing to write %s, which is a symlink", filename)
+	}
+	return filename, nil
+}
+
+// joinDest joins the slash separated name to destDir, as long as the result is inside
+// of destDir.
+func joinDest(destDir string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("refusing to write %s outside of %s", name, destDir)
+	}
+	filename := filepath.Join(destDir, filepath.FromSlash(name))
+	if !isWithin(filepath.Clean(destDir), filename) {
+		return "", fmt.Errorf("refusing to write %s outside of %s", name, destDir)
+	}
+	return filename, nil
+}
+
+// makeParentWithin creates the directory that will hold filename. The part of the path
+// that already exists is checked first, so that no directories are created through a
+// symlink to somewhere outside of destDir.
+func makeParentWithin(destDir string, filename string) error {
+	err := os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return err
+	}
+	existing := filepath.Dir(filename)
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		existing = filepath.Dir(existing)
+	}
+	err = checkDirWithin(destDir, existing, filename)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return checkParentWithin(destDir, filename)
+}
+
+// checkParentWithin makes sure that the directory holding filename, which must already
+// exist, isn't a symlink to somewhere outside of destDir.
+func checkParentWithin(destDir string, filename string) error {
+	return checkDirWithin(destDir, filepath.Dir(filename), filename)
+}
+
+// checkDirWithin makes sure that dir, which must already exist, isn't a symlink to
+// somewhere outside of destDir. filename is the file that will be written there.
+func checkDirWithin(destDir string, dir string, filename string) error {
+	realDest, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if realDir != realDest && !isWithin(realDest, realDir) {
+		return fmt.Errorf("refusing to write %s, which is linked outside of %s", filename, destDir)
+	}
+	return nil
+}
+
+// isWithin reports whether filename is inside of dir. Both must be clean paths.
+func isWithin(dir string, filename string) bool {
+	relative, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return false
+	}
+	return relative != "." && relative != ".." &&
+		!strings.HasPrefix(relative, ".."+string(filepath.Separator)) && !filepath.IsAbs(relative)
+}
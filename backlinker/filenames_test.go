@@ -0,0 +1,162 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizePageName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Plain Page", "Plain Page"},
+		{"../../../tmp/evil", "tmp-evil"},
+		{"/etc/passwd", "etc-passwd"},
+		{`..\..\windows`, "windows"},
+		{"a/b", "a-b"},
+		{"What? Why: <this>", "What- Why- -this-"},
+		{"null\x00byte", "nullbyte"},
+		{".hidden", "hidden"},
+		{"trailing. ", "trailing"},
+		{"..", "untitled"},
+		{"con", "_con"},
+		{"Ünïcödé stays", "Ünïcödé stays"},
+		{strings.Repeat("é", 150), strings.Repeat("é", 100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, sanitizePageName(tt.name))
+		})
+	}
+}
+
+func TestUrlize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Name With Spaces", "name-with-spaces"},
+		{"  Extra   spaces  ", "extra-spaces"},
+		{"What? Why: (this)!", "what-why-this"},
+		{"Before - after", "before-after"},
+		{"double--dash", "double--dash"},
+		{"C++ & C#", "c++-c%23"},
+		{"v1.2_notes@home~", "v1.2_notes@home~"},
+		{"Ünïcödé Café", "ünïcödé-café"},
+		{"100%25 sure 50%", "100%25-sure-50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, urlize(tt.name))
+		})
+	}
+}
+
+func TestDestPathStaysInDest(t *testing.T) {
+	require := require.New(t)
+	destDir, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(err)
+	defer os.RemoveAll(destDir)
+	outside, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(err)
+	defer os.RemoveAll(outside)
+
+	filename, err := destPath(destDir, "projects/Phoenix.md")
+	require.Nil(err)
+	require.Equal(filepath.Join(destDir, "projects", "Phoenix.md"), filename)
+
+	_, err = destPath(destDir, "../evil.md")
+	require.NotNil(err)
+	_, err = destPath(destDir, "projects/../../evil.md")
+	require.NotNil(err)
+	_, err = destPath(destDir, "/tmp/evil.md")
+	require.NotNil(err)
+
+	require.Nil(os.Symlink(outside, filepath.Join(destDir, "linked")))
+	_, err = destPath(destDir, "linked/evil.md")
+	require.NotNil(err, "Symlinked directories should not be followed out of dest")
+	_, err = destPath(destDir, "linked/inner/deeper/evil.md")
+	require.NotNil(err)
+	_, err = os.Stat(filepath.Join(outside, "inner"))
+	require.True(os.IsNotExist(err), "No directories should be created outside of dest")
+
+	require.Nil(os.Symlink(filepath.Join(outside, "target.md"), filepath.Join(destDir, "file.md")))
+	_, err = destPath(destDir, "file.md")
+	require.NotNil(err, "Symlinked files should not be written through")
+}
+
+func TestTraversalLinksStayInDest(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Notes.md": "Sneaky [[../../../tmp/evil]] and [[a/b]] links\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	parent, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(err)
+	defer os.RemoveAll(parent)
+	destDir := filepath.Join(parent, "dest")
+	require.Nil(os.Mkdir(destDir, 0755))
+
+	err = ProcessBackLinks(testBuildOptions(sourceDir, destDir))
+	require.Nil(err)
+	names := make([]string, 0)
+	err = filepath.Walk(parent, func(filename string, info os.FileInfo, err error) error {
+		if !info.IsDir() {
+			names = append(names, filepath.ToSlash(strings.TrimPrefix(filename, parent)))
+		}
+		return err
+	})
+	require.Nil(err)
+	require.ElementsMatch([]string{
+		"/dest/Notes.md", "/dest/tmp-evil.md", "/dest/a-b.md", "/dest/" + CacheFile, "/dest/" + ManifestFile,
+	}, names)
+
+	notes, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+	require.Nil(err)
+	require.Contains(string(notes), "Sneaky [../../../tmp/evil](../tmp-evil/)")
+	evil, err := ioutil.ReadFile(filepath.Join(destDir, "tmp-evil.md"))
+	require.Nil(err)
+	require.Contains(string(evil), `title = "../../../tmp/evil"`)
+}
+
+func TestLinksToNotesWithUnsafeNames(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md":       "See [[What?]], [[C++: Notes]] and [[Really...]]\n",
+		"What?.md":      "A question\n",
+		"C++: Notes.md": "Pointers\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	require.Nil(ProcessBackLinks(testBuildOptions(sourceDir, destDir)))
+	for _, name := range []string{"What-.md", "C++- Notes.md"} {
+		_, err := os.Stat(filepath.Join(destDir, name))
+		require.True(os.IsNotExist(err), "%s should not be created", name)
+	}
+	home, err := ioutil.ReadFile(filepath.Join(destDir, "Home.md"))
+	require.Nil(err)
+	require.Contains(string(home), "See [What?](../what/), [C++: Notes](../c++-notes/) and [Really...](../really/)\n")
+	question, err := ioutil.ReadFile(filepath.Join(destDir, "What?.md"))
+	require.Nil(err)
+	require.Contains(string(question), "[Home](../home/)")
+	notes, err := ioutil.ReadFile(filepath.Join(destDir, "C++: Notes.md"))
+	require.Nil(err)
+	require.Contains(string(notes), "[Home](../home/)")
+	_, err = os.Stat(filepath.Join(destDir, "Really.md"))
+	require.Nil(err, "New pages still get a sanitized name")
+
+	edits, err := Rename(testBuildOptions(sourceDir, destDir), "What?", "Why?", false, true)
+	require.Nil(err)
+	require.Len(edits, 2)
+	require.Equal("Home.md", edits[0].OldName)
+	require.Contains(edits[0].Diff(), "+See [[Why?]], [[C++: Notes]] and [[Really...]]\n")
+	require.Equal("Why-.md", edits[1].NewName, "The new name of the file is sanitized, and links find it")
+}
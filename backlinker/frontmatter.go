@@ -0,0 +1,200 @@
+package backlinker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/naoina/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// These are the frontmatter formats that Hugo understands. FrontmatterSame is only valid
+// for output, and means "write the frontmatter in whichever format the file used".
+const (
+	FrontmatterTOML = "toml"
+	FrontmatterYAML = "yaml"
+	FrontmatterJSON = "json"
+	FrontmatterSame = "same"
+)
+
+// frontmatterFences are the lines that open and close the delimited frontmatter formats.
+// JSON frontmatter is just a JSON object, so it has no fence.
+var frontmatterFences = map[string]string{
+	FrontmatterTOML: "+++",
+	FrontmatterYAML: "---",
+}
+
+// dateLayouts are the date formats accepted in YAML and JSON frontmatter, which (unlike
+// TOML) don't have a native date type.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// dateKeys are the metadata fields that Hugo treats as dates.
+var dateKeys = []string{"date", "lastmod", "publishdate", "expirydate"}
+
+// ValidFrontmatterFormat reports whether format can be used for the output frontmatter.
+func ValidFrontmatterFormat(format string) bool {
+	switch format {
+	case FrontmatterTOML, FrontmatterYAML, FrontmatterJSON, FrontmatterSame:
+		return true
+	}
+	return false
+}
+
+// detectFrontmatterFormat looks at the first line of a file to see which kind of
+// frontmatter it starts with. An empty string means there is no frontmatter.
+func detectFrontmatterFormat(firstLine string) string {
+	switch {
+	case firstLine == frontmatterFences[FrontmatterTOML]:
+		return FrontmatterTOML
+	case firstLine == frontmatterFences[FrontmatterYAML]:
+		return FrontmatterYAML
+	case isJSONObjectStart(firstLine):
+		return FrontmatterJSON
+	}
+	return ""
+}
+
+// isJSONObjectStart reports whether the line opens a JSON object: a brace followed by
+// nothing, a key or the closing brace. Other lines that start with a brace, like a Hugo
+// shortcode ({{< toc >}}), are part of the body.
+func isJSONObjectStart(line string) bool {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return false
+	}
+	token, err = decoder.Token()
+	if err == io.EOF {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	_, isKey := token.(string)
+	return isKey || token == json.Delim('}')
+}
+
+// parseFrontmatter decodes the frontmatter block (without its fences) into metadata.
+func parseFrontmatter(format string, data []byte) (map[string]interface{}, error) {
+	meta := make(map[string]interface{})
+	var err error
+	switch format {
+	case FrontmatterTOML:
+		err = toml.Unmarshal(data, meta)
+	case FrontmatterYAML:
+		err = yaml.Unmarshal(data, &meta)
+	case FrontmatterJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&meta)
+	default:
+		err = fmt.Errorf("unknown frontmatter format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta = normalizeMetadataValue(meta).(map[string]interface{})
+	for _, key := range dateKeys {
+		if dateString, isString := meta[key].(string); isString {
+			for _, layout := range dateLayouts {
+				date, err := time.Parse(layout, dateString)
+				if err == nil {
+					meta[key] = date
+					break
+				}
+			}
+		}
+	}
+	return meta, nil
+}
+
+// normalizeMetadataValue converts the types that the YAML and JSON decoders produce into
+// the types that the TOML decoder produces, so that the metadata can be written back out
+// in any of the formats. Empty values (like "description:" in YAML) decode to nil, which
+// TOML has no way to write, so they're left out.
+func normalizeMetadataValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			if inner = normalizeMetadataValue(inner); inner != nil {
+				result[fmt.Sprint(key)] = inner
+			}
+		}
+		return result
+	case map[string]interface{}:
+		for key, inner := range v {
+			if inner = normalizeMetadataValue(inner); inner != nil {
+				v[key] = inner
+			} else {
+				delete(v, key)
+			}
+		}
+		return v
+	case []interface{}:
+		result := v[:0]
+		for _, inner := range v {
+			if inner = normalizeMetadataValue(inner); inner != nil {
+				result = append(result, inner)
+			}
+		}
+		return result
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	}
+	return value
+}
+
+// formatFrontmatter encodes the metadata as a complete frontmatter block, including fences.
+func formatFrontmatter(format string, meta map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FrontmatterTOML:
+		data, err := toml.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("+++\n")
+		buf.Write(data)
+		buf.WriteString("+++\n")
+	case FrontmatterYAML:
+		data, err := yaml.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+		buf.WriteString("---\n")
+	case FrontmatterJSON:
+		data, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	default:
+		return nil, fmt.Errorf("unknown frontmatter format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// metadataDate retrieves the date from a page's metadata, if it has one.
+func metadataDate(meta map[string]interface{}) (time.Time, bool) {
+	date, isDate := meta["date"].(time.Time)
+	return date, isDate
+}
@@ -0,0 +1,151 @@
+package backlinker
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestYAMLFrontmatter(t *testing.T) {
+	require := require.New(t)
+	file := createMarkdownFile("AFile.md", false, testOptions)
+	scanner := bufio.NewScanner(strings.NewReader(`---
+title: From YAML
+date: 2019-08-26
+tags:
+  - one
+  - two
+---
+The body
+`))
+	err := extractFrontmatter(file, scanner)
+	require.Nil(err)
+	require.Equal("", file.firstLine)
+	require.Equal(FrontmatterYAML, file.frontmatterFormat)
+	date, hasDate := metadataDate(file.metadata)
+	require.True(hasDate, "YAML date should be parsed into a time")
+	require.Equal(2019, date.Year())
+	require.Equal([]interface{}{"one", "two"}, file.metadata["tags"])
+	require.True(scanner.Scan())
+	require.Equal("The body", scanner.Text())
+
+	writer := bytes.Buffer{}
+	err = adjustFrontmatter(file, testOptions, &writer)
+	require.Nil(err)
+	require.Equal("From YAML", file.Title)
+	output := writer.String()
+	require.True(strings.HasPrefix(output, "+++\n"))
+	require.Contains(output, "date = 2019-08-26T00:00:00Z")
+}
+
+func TestJSONFrontmatter(t *testing.T) {
+	require := require.New(t)
+	file := createMarkdownFile("AFile.md", false, testOptions)
+	scanner := bufio.NewScanner(strings.NewReader(`{
+  "title": "From JSON",
+  "date": "2020-04-25T19:00:00Z",
+  "weight": 3,
+  "params": {"nested": {"brace": "}"}}
+}
+The body
+`))
+	err := extractFrontmatter(file, scanner)
+	require.Nil(err)
+	require.Equal(FrontmatterJSON, file.frontmatterFormat)
+	require.Equal(int64(3), file.metadata["weight"])
+	date, hasDate := metadataDate(file.metadata)
+	require.True(hasDate, "JSON date should be parsed into a time")
+	require.Equal(time.Date(2020, 4, 25, 19, 0, 0, 0, time.UTC), date)
+	require.True(scanner.Scan())
+	require.Equal("The body", scanner.Text())
+}
+
+func TestUnterminatedYAMLFrontmatter(t *testing.T) {
+	file := createMarkdownFile("AFile.md", false, testOptions)
+	scanner := bufio.NewScanner(strings.NewReader("---\ntitle: Oops\n"))
+	err := extractFrontmatter(file, scanner)
+	require.NotNil(t, err)
+}
+
+func TestFrontmatterOutputFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format string
+		prefix string
+		want   string
+	}{
+		{"TOML to YAML", "+++\ntitle = \"T\"\n+++\n", FrontmatterYAML, "---\n", "title: T\n---\n"},
+		{"YAML to JSON", "---\ntitle: T\n---\n", FrontmatterJSON, "{\n", "\"title\": \"T\"\n}\n"},
+		{"Same keeps YAML", "---\ntitle: T\n---\n", FrontmatterSame, "---\n", "title: T\n---\n"},
+		{"Same keeps JSON", "{\"title\": \"T\"}\n", FrontmatterSame, "{\n", "\"title\": \"T\"\n}\n"},
+		{"Same without frontmatter is TOML", "Just text\n", FrontmatterSame, "+++\n", "+++\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			file := createMarkdownFile("AFile.md", false, testOptions)
+			err := extractFrontmatter(file, bufio.NewScanner(strings.NewReader(tt.input)))
+			require.Nil(err)
+			writer := bytes.Buffer{}
+			options := DefaultOptions()
+			options.FrontmatterFormat = tt.format
+			err = adjustFrontmatter(file, options, &writer)
+			require.Nil(err)
+			output := writer.String()
+			require.True(strings.HasPrefix(output, tt.prefix), output)
+			require.True(strings.HasSuffix(output, tt.want), output)
+		})
+	}
+}
+
+func TestShortcodeIsNotJSONFrontmatter(t *testing.T) {
+	tests := []string{
+		"{{< toc >}}\nThe body\n",
+		"{{% notice %}}\nThe body\n",
+		"{not json}\nThe body\n",
+	}
+	for _, input := range tests {
+		require := require.New(t)
+		file := createMarkdownFile("AFile.md", false, testOptions)
+		err := extractFrontmatter(file, bufio.NewScanner(strings.NewReader(input)))
+		require.Nil(err, input)
+		require.Equal("", file.frontmatterFormat, input)
+		require.Equal(0, file.bodyLine, input)
+		require.Empty(file.metadata, input)
+	}
+	require.Equal(t, FrontmatterJSON, detectFrontmatterFormat("{"))
+	require.Equal(t, FrontmatterJSON, detectFrontmatterFormat("{}"))
+	require.Equal(t, FrontmatterJSON, detectFrontmatterFormat(`{"title": "T",`))
+}
+
+func TestEmptyYAMLValues(t *testing.T) {
+	require := require.New(t)
+	file := createMarkdownFile("AFile.md", false, testOptions)
+	scanner := bufio.NewScanner(strings.NewReader(`---
+title: Empty values
+description:
+params:
+  summary:
+  weight: 2
+tags:
+  - one
+  -
+---
+The body
+`))
+	err := extractFrontmatter(file, scanner)
+	require.Nil(err)
+	require.NotContains(file.metadata, "description")
+	require.Equal(map[string]interface{}{"weight": 2}, file.metadata["params"])
+	require.Equal([]interface{}{"one"}, file.metadata["tags"])
+
+	writer := bytes.Buffer{}
+	err = adjustFrontmatter(file, testOptions, &writer)
+	require.Nil(err)
+	require.Contains(writer.String(), "title = \"Empty values\"")
+}
@@ -0,0 +1,281 @@
+package backlinker
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// These are the formats that the note graph can be exported in: JSON (with nodes and
+// links, which is what d3 and force-graph use), GraphML (for Gephi and other graph tools)
+// and Graphviz DOT.
+const (
+	GraphJSON    = "json"
+	GraphGraphML = "graphml"
+	GraphDOT     = "dot"
+)
+
+// graphFormatForFile finds the graph format from the extension of the file, or returns
+// an empty string if it isn't one of the formats.
+func graphFormatForFile(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return GraphJSON
+	case ".graphml":
+		return GraphGraphML
+	case ".dot", ".gv":
+		return GraphDOT
+	}
+	return ""
+}
+
+// graphNode is a published page in the note graph.
+type graphNode struct {
+	// ID is the name of the page's file, which is unique.
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Slug is the path of the page's URL, within the section.
+	Slug string     `json:"slug"`
+	Date *time.Time `json:"date,omitempty"`
+	Tags []string   `json:"tags,omitempty"`
+	// IsNew is true for the pages which only exist because they are linked to.
+	IsNew bool `json:"new"`
+}
+
+// graphEdge is a link from one page (Source) to another (Target).
+type graphEdge struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Kind    string `json:"kind"`
+	Context string `json:"context"`
+	Section string `json:"section,omitempty"`
+	Block   string `json:"block,omitempty"`
+}
+
+// noteGraph is every published page, and the links between them.
+type noteGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"links"`
+}
+
+// buildGraph gathers the graph from the backlinks of the pages. The titles and dates of
+// the pages need to be resolved first (see resolveTitles). Private pages are left out.
+func buildGraph(fileMap map[string]*markdownFile) *noteGraph {
+	graph := &noteGraph{
+		Nodes: make([]graphNode, 0, len(fileMap)),
+		Edges: make([]graphEdge, 0),
+	}
+	for _, file := range fileMap {
+		if file.IsPrivate {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, newGraphNode(file))
+		for _, bl := range file.BackLinks {
+			if bl.OtherFile.IsPrivate {
+				continue
+			}
+			graph.Edges = append(graph.Edges, graphEdge{
+				Source:  bl.OtherFile.OriginalName,
+				Target:  file.OriginalName,
+				Kind:    bl.Kind,
+				Context: bl.Context,
+				Section: bl.Section,
+				Block:   bl.Block,
+			})
+		}
+	}
+	graph.sort()
+	return graph
+}
+
+// newGraphNode describes the page for the graph.
+func newGraphNode(file *markdownFile) graphNode {
+	node := graphNode{
+		ID:    file.OriginalName,
+		Title: file.Title,
+		Slug:  strings.Join(pagePath(file), "/"),
+		Tags:  metadataStrings(file.metadata, "tags"),
+		IsNew: file.IsNew,
+	}
+	if date, hasDate := metadataDate(file.metadata); hasDate {
+		node.Date = &date
+	}
+	return node
+}
+
+// sort puts the nodes and edges in a consistent order, so that the output only changes
+// when the graph does.
+func (graph *noteGraph) sort() {
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.SliceStable(graph.Edges, func(i, j int) bool {
+		e1 := graph.Edges[i]
+		e2 := graph.Edges[j]
+		if e1.Source != e2.Source {
+			return e1.Source < e2.Source
+		}
+		if e1.Target != e2.Target {
+			return e1.Target < e2.Target
+		}
+		return e1.Context < e2.Context
+	})
+}
+
+// write writes the graph in the format.
+func (graph *noteGraph) write(format string, writer io.Writer) error {
+	switch format {
+	case GraphJSON:
+		data, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(append(data, '\n'))
+		return err
+	case GraphGraphML:
+		return graph.writeGraphML(writer)
+	case GraphDOT:
+		return graph.writeDOT(writer)
+	}
+	return fmt.Errorf("unknown graph format %q", format)
+}
+
+// graphMLKeys are the attributes of the nodes and edges in GraphML.
+const graphMLKeys = `  <key id="title" for="node" attr.name="title" attr.type="string"/>
+  <key id="slug" for="node" attr.name="slug" attr.type="string"/>
+  <key id="date" for="node" attr.name="date" attr.type="string"/>
+  <key id="tags" for="node" attr.name="tags" attr.type="string"/>
+  <key id="new" for="node" attr.name="new" attr.type="boolean"/>
+  <key id="kind" for="edge" attr.name="kind" attr.type="string"/>
+  <key id="context" for="edge" attr.name="context" attr.type="string"/>
+  <key id="section" for="edge" attr.name="section" attr.type="string"/>
+  <key id="block" for="edge" attr.name="block" attr.type="string"/>
+`
+
+// writeGraphML writes the graph as GraphML. Tags are joined with commas, since GraphML
+// attributes can't be lists.
+func (graph *noteGraph) writeGraphML(writer io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	out.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	out.WriteString(graphMLKeys)
+	out.WriteString(`  <graph id="notes" edgedefault="directed">` + "\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&out, "    <node id=\"%s\">\n", xmlText(node.ID))
+		writeGraphMLData(&out, "title", node.Title)
+		writeGraphMLData(&out, "slug", node.Slug)
+		if node.Date != nil {
+			writeGraphMLData(&out, "date", node.Date.Format(time.RFC3339))
+		}
+		writeGraphMLData(&out, "tags", strings.Join(node.Tags, ","))
+		writeGraphMLData(&out, "new", fmt.Sprint(node.IsNew))
+		out.WriteString("    </node>\n")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&out, "    <edge source=\"%s\" target=\"%s\">\n", xmlText(edge.Source), xmlText(edge.Target))
+		writeGraphMLData(&out, "kind", edge.Kind)
+		writeGraphMLData(&out, "context", edge.Context)
+		writeGraphMLData(&out, "section", edge.Section)
+		writeGraphMLData(&out, "block", edge.Block)
+		out.WriteString("    </edge>\n")
+	}
+	out.WriteString("  </graph>\n</graphml>\n")
+	_, err := writer.Write(out.Bytes())
+	return err
+}
+
+// writeGraphMLData writes the value of an attribute, unless it's empty.
+func writeGraphMLData(out *bytes.Buffer, key string, value string) {
+	if value != "" {
+		fmt.Fprintf(out, "      <data key=\"%s\">%s</data>\n", key, xmlText(value))
+	}
+}
+
+// xmlText escapes the text for XML.
+func xmlText(text string) string {
+	var escaped bytes.Buffer
+	// Writing to a bytes.Buffer never fails
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// writeDOT writes the graph for Graphviz. The pages are labeled with their titles, and
+// the rest of the data is kept in attributes that Graphviz ignores, apart from the
+// context of each link, which becomes its tooltip.
+func (graph *noteGraph) writeDOT(writer io.Writer) error {
+	var out bytes.Buffer
+	out.WriteString("digraph notes {\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&out, "  %s [label=%s, slug=%s", dotString(node.ID), dotString(node.Title),
+			dotString(node.Slug))
+		if node.Date != nil {
+			fmt.Fprintf(&out, ", date=%s", dotString(node.Date.Format(time.RFC3339)))
+		}
+		if len(node.Tags) > 0 {
+			fmt.Fprintf(&out, ", tags=%s", dotString(strings.Join(node.Tags, ",")))
+		}
+		if node.IsNew {
+			out.WriteString(", new=true, style=dashed")
+		}
+		out.WriteString("];\n")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&out, "  %s -> %s [kind=%s, tooltip=%s];\n", dotString(edge.Source),
+			dotString(edge.Target), dotString(edge.Kind), dotString(edge.Context))
+	}
+	out.WriteString("}\n")
+	_, err := writer.Write(out.Bytes())
+	return err
+}
+
+// dotString quotes the text for DOT.
+func dotString(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(text) + `"`
+}
+
+// Graph reads the notes and leaves out the private ones the same way that ProcessBackLinks
+// does (see readNotes and publishNotes), and then writes the graph of the published
+// notes and the links between them in the format. Nothing else is written.
+func Graph(options *Options, format string, writer io.Writer) error {
+	err := options.validateReading()
+	if err != nil {
+		return err
+	}
+	pages, _, err := readNotes(options)
+	if err != nil {
+		return err
+	}
+	err = publishNotes(pages, options)
+	if err != nil {
+		return err
+	}
+	return buildGraph(pages.files).write(format, writer)
+}
+
+// writeGraphOutput writes the graph to options.GraphOutput as part of a build, if it's
+// set. Like the pages, the file is only written when it changes.
+func writeGraphOutput(fileMap map[string]*markdownFile, options *Options) error {
+	if options.GraphOutput == "" {
+		return nil
+	}
+	var out bytes.Buffer
+	err := buildGraph(fileMap).write(graphFormatForFile(options.GraphOutput), &out)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(options.GraphOutput)
+	if err == nil && bytes.Equal(existing, out.Bytes()) {
+		return nil
+	}
+	log.Printf("Writing the graph to %s\n", options.GraphOutput)
+	return ioutil.WriteFile(options.GraphOutput, out.Bytes(), 0644)
+}
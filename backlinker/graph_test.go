@@ -0,0 +1,87 @@
+package backlinker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeGraphNotes(t *testing.T) string {
+	return writeTestFiles(t, map[string]string{
+		"2020-05-01.md": "Started on [[Phoenix]] with \"quotes\" & <brackets>\n",
+		"Phoenix.md":    "---\ntags: [project]\n---\nSee [[Budget]] #planning\n",
+		"Secret.md":     "---\nprivate: true\n---\nAbout [[Phoenix]]\n",
+	})
+}
+
+func TestGraph(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeGraphNotes(t)
+	defer os.RemoveAll(sourceDir)
+	options := testBuildOptions(sourceDir, "")
+	options.Hashtags = HashtagsBoth
+
+	var out bytes.Buffer
+	require.Nil(Graph(options, GraphJSON, &out))
+	var graph noteGraph
+	require.Nil(json.Unmarshal(out.Bytes(), &graph))
+	ids := make([]string, 0, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		ids = append(ids, node.ID)
+	}
+	require.Equal([]string{"2020-05-01.md", "Budget.md", "Phoenix.md", "planning.md"}, ids)
+	phoenix := graph.Nodes[2]
+	require.Equal("Phoenix", phoenix.Title)
+	require.Equal("phoenix", phoenix.Slug)
+	require.Equal([]string{"project", "planning"}, phoenix.Tags)
+	require.Equal("2020-05-01T08:00:00Z", phoenix.Date.Format("2006-01-02T15:04:05Z07:00"))
+	require.True(graph.Nodes[1].IsNew)
+	require.Len(graph.Edges, 3)
+	require.Equal(graphEdge{
+		Source:  "2020-05-01.md",
+		Target:  "Phoenix.md",
+		Kind:    linkBacklink,
+		Context: "Started on [[Phoenix]] with \"quotes\" & <brackets>",
+	}, graph.Edges[0])
+	require.Equal(tagBacklink, graph.Edges[2].Kind)
+
+	out.Reset()
+	require.Nil(Graph(options, GraphGraphML, &out))
+	require.Contains(out.String(), `<node id="Phoenix.md">`)
+	require.Contains(out.String(), `<edge source="2020-05-01.md" target="Phoenix.md">`)
+	require.Contains(out.String(), `<data key="context">Started on [[Phoenix]] with &#34;quotes&#34; &amp; &lt;brackets&gt;</data>`)
+	require.NotContains(out.String(), "Secret")
+
+	out.Reset()
+	require.Nil(Graph(options, GraphDOT, &out))
+	require.Contains(out.String(), `"Phoenix.md" [label="Phoenix", slug="phoenix", date="2020-05-01T08:00:00Z", tags="project,planning"];`)
+	require.Contains(out.String(), `"planning.md" [label="planning", slug="planning"`)
+	require.Contains(out.String(), `new=true, style=dashed];`)
+	require.Contains(out.String(), `"2020-05-01.md" -> "Phoenix.md" [kind="link", tooltip="Started on [[Phoenix]] with \"quotes\" & <brackets>"];`)
+
+	require.NotNil(Graph(options, "csv", &out))
+}
+
+func TestGraphOutput(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeGraphNotes(t)
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, filepath.Join(destDir, "content"))
+	require.Nil(os.Mkdir(options.Dest, 0755))
+	options.GraphOutput = filepath.Join(destDir, "graph.dot")
+
+	require.Nil(ProcessBackLinks(options))
+	output, err := ioutil.ReadFile(options.GraphOutput)
+	require.Nil(err)
+	require.Contains(string(output), "digraph notes {\n")
+
+	options.GraphOutput = filepath.Join(destDir, "graph.csv")
+	require.NotNil(options.Validate())
+}
@@ -0,0 +1,146 @@
+package backlinker
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// hashtagAttribute marks the nodes in the goldmark AST that came from #tags.
+const hashtagAttribute = "sharedbrain-hashtag"
+
+// These are the ways that #tags can be used: not at all (the default), as links to a page
+// for the tag (like Roam), as Hugo taxonomy terms in the frontmatter tags, or both.
+const (
+	HashtagsNone     = "none"
+	HashtagsPages    = "pages"
+	HashtagsTaxonomy = "taxonomy"
+	HashtagsBoth     = "both"
+)
+
+// isTagRune reports whether the rune can be part of a #tag. Slashes are allowed for
+// nested tags, like #project/phoenix.
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '/'
+}
+
+// hashtagParser finds #tags and #[[multi word tags]].
+type hashtagParser struct{}
+
+// Trigger fulfills the goldmark InlineParser interface.
+func (hp hashtagParser) Trigger() []byte {
+	return []byte{'#'}
+}
+
+// Parse turns a #tag into a link node with the name of the tag as its text. Like in
+// Obsidian, the # has to come at the start of a word and a tag can't be only digits,
+// so that things like issue numbers aren't tags.
+func (hp hashtagParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	preceding := block.PrecendingCharacter()
+	if !unicode.IsSpace(preceding) && preceding != '(' {
+		return nil
+	}
+	line, segment := block.PeekLine()
+	start := 1
+	stop := 1
+	length := 0
+	if bytes.HasPrefix(line, []byte("#[[")) {
+		end := bytes.Index(line, []byte("]]"))
+		if end == -1 || len(bytes.TrimSpace(line[3:end])) == 0 {
+			return nil
+		}
+		start = 3
+		stop = end
+		length = end + 2
+	} else {
+		onlyDigits := true
+		for stop < len(line) {
+			r, size := utf8.DecodeRune(line[stop:])
+			if !isTagRune(r) {
+				break
+			}
+			onlyDigits = onlyDigits && unicode.IsDigit(r)
+			stop += size
+		}
+		if stop == 1 || onlyDigits {
+			return nil
+		}
+		length = stop
+	}
+	block.Advance(length)
+	link := ast.NewLink()
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(segment.Start+start, segment.Start+stop)))
+	link.SetAttributeString(hashtagAttribute, true)
+	return link
+}
+
+// hashtagSpan describes a #tag, which is a link to the page for the tag.
+func hashtagSpan(node ast.Node, tagText *ast.Text, source []byte) wikilinkSpan {
+	tag := string(tagText.Segment.Value(source))
+	start := tagText.Segment.Start - 1
+	stop := tagText.Segment.Stop
+	if source[start] == '[' {
+		start -= 2
+		stop += 2
+	}
+	return wikilinkSpan{
+		Start:   start,
+		Stop:    stop,
+		Text:    tag,
+		Context: blockContext(node, source),
+		Link:    wikilink{Target: tag, Display: "#" + tag},
+		Hashtag: true,
+	}
+}
+
+// bracketed reports whether the span is a #[[multi word tag]], rather than a #tag.
+func (span wikilinkSpan) bracketed() bool {
+	return span.Stop-span.Start == len(span.Text)+len("#[[]]")
+}
+
+// hashtagAsWikilink reports whether the #[[tag]] is read as a # in front of an ordinary
+// wikilink, which is what it was before #tags were supported (see HashtagsNone).
+func (options *Options) hashtagAsWikilink(span wikilinkSpan) bool {
+	return span.Hashtag && options.Hashtags == HashtagsNone && span.bracketed()
+}
+
+// tagPages reports whether #tags link to pages.
+func (options *Options) tagPages() bool {
+	return options.Hashtags == HashtagsPages || options.Hashtags == HashtagsBoth
+}
+
+// tagTaxonomy reports whether #tags are added to the frontmatter tags.
+func (options *Options) tagTaxonomy() bool {
+	return options.Hashtags == HashtagsTaxonomy || options.Hashtags == HashtagsBoth
+}
+
+// mergeHashtags adds the #tags in each note to the tags in its frontmatter, so that
+// Hugo's taxonomies include them. This is done after the cache is saved, so that the
+// cache only has the frontmatter that was in the note.
+func mergeHashtags(fileMap map[string]*markdownFile, options *Options) {
+	if !options.tagTaxonomy() {
+		return
+	}
+	for _, file := range fileMap {
+		tags := metadataStrings(file.metadata, "tags")
+		seen := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			seen[tag] = true
+		}
+		added := false
+		for _, span := range file.links {
+			if span.Hashtag && !seen[span.Text] {
+				seen[span.Text] = true
+				tags = append(tags, span.Text)
+				added = true
+			}
+		}
+		if added {
+			file.metadata["tags"] = tags
+		}
+	}
+}
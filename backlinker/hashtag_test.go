@@ -0,0 +1,86 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindHashtags(t *testing.T) {
+	require := require.New(t)
+	source := []byte("# Heading\n\nAbout #phoenix and #[[big ideas]] (#nested/tag).\n\n" +
+		"Not tags: issue #42, page.html#anchor, `#code` and ##double\n")
+	spans := findWikilinks(parseMarkdown(source), source)
+	require.Len(spans, 3)
+	require.Equal("#phoenix", string(source[spans[0].Start:spans[0].Stop]))
+	require.Equal("phoenix", spans[0].Link.Target)
+	require.True(spans[0].Hashtag)
+	require.Equal("#[[big ideas]]", string(source[spans[1].Start:spans[1].Stop]))
+	require.Equal("big ideas", spans[1].Link.Target)
+	require.Equal("#big ideas", spans[1].Link.Display)
+	require.Equal("nested/tag", spans[2].Text)
+}
+
+func TestHashtags(t *testing.T) {
+	notes := map[string]string{
+		"Notes.md":   "---\ntags: [work]\n---\nWorking on #phoenix and #[[big ideas]] for #work\n",
+		"Phoenix.md": "The project\n",
+	}
+	tests := []struct {
+		mode    string
+		notes   string
+		tags    string
+		tagPage bool
+	}{
+		{HashtagsPages, "Working on [#phoenix](../phoenix/) and [#big ideas](../big-ideas/)", "tags = [\"work\"]\n", true},
+		{HashtagsTaxonomy, "Working on #phoenix and #big ideas for #work\n", "tags = [\"work\", \"phoenix\", \"big ideas\"]\n", false},
+		{HashtagsBoth, "Working on [#phoenix](../phoenix/) and [#big ideas](../big-ideas/)", "tags = [\"work\", \"phoenix\", \"big ideas\"]\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			require := require.New(t)
+			sourceDir := writeTestFiles(t, notes)
+			defer os.RemoveAll(sourceDir)
+			destDir := writeTestFiles(t, nil)
+			defer os.RemoveAll(destDir)
+			options := testBuildOptions(sourceDir, destDir)
+			options.Hashtags = tt.mode
+
+			require.Nil(ProcessBackLinks(options))
+			output, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+			require.Nil(err)
+			require.Contains(string(output), tt.notes)
+			require.Contains(string(output), tt.tags)
+			_, err = os.Stat(filepath.Join(destDir, "big ideas.md"))
+			require.Equal(tt.tagPage, err == nil, "Pages for tags")
+			phoenix, err := ioutil.ReadFile(filepath.Join(destDir, "Phoenix.md"))
+			require.Nil(err)
+			require.Equal(tt.tagPage, len(phoenix) > len("+++\ntitle = \"Phoenix\"\n+++\nThe project\n"),
+				"Backlinks from tags")
+		})
+	}
+}
+
+func TestHashtagsAreOffByDefault(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Notes.md": "---\ntags: [work]\n---\nThe color #fff goes with #[[Big Ideas]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	require.Nil(ProcessBackLinks(testBuildOptions(sourceDir, destDir)))
+	output, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+	require.Nil(err)
+	require.Contains(string(output), "tags = [\"work\"]\n")
+	require.Contains(string(output), "The color #fff goes with #[Big Ideas](../big-ideas/)\n")
+	_, err = os.Stat(filepath.Join(destDir, "fff.md"))
+	require.True(os.IsNotExist(err), "No page is created for #fff")
+	ideas, err := ioutil.ReadFile(filepath.Join(destDir, "Big Ideas.md"))
+	require.Nil(err)
+	require.Contains(string(ideas), "* [Notes](../notes/)\n")
+}
@@ -0,0 +1,85 @@
+package backlinker
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// These are the ways that links between pages can be written (see pageLink): relative
+// to the page (using the link format), from the root of the site, as absolute URLs,
+// as relative links to the markdown files (which GitHub and Gitea follow) or with Hugo's
+// ref shortcode.
+const (
+	LinksRelative = "relative"
+	LinksRoot     = "root"
+	LinksAbsolute = "absolute"
+	LinksMarkdown = "markdown"
+	LinksRef      = "ref"
+)
+
+// validLinkStrategy reports whether the strategy is one of the known ones.
+func validLinkStrategy(strategy string) bool {
+	switch strategy {
+	case LinksRelative, LinksRoot, LinksAbsolute, LinksMarkdown, LinksRef:
+		return true
+	}
+	return false
+}
+
+// pageLink creates the link from the page generated for `from` to the page for the file,
+// using the configured link strategy. This is used for every link to a page, whether
+// it's in the text of a page or in its backlinks. A url in the file's frontmatter is
+// used as it is (apart from the base URL for absolute links), the same as Hugo does.
+// A page with a url of its own isn't in its folder, so relative links from it would go
+// to the wrong place. Links from it start at the root of the site instead.
+func pageLink(from string, file *markdownFile, pages *pageIndex, options *Options) string {
+	switch options.LinkStrategy {
+	case LinksMarkdown:
+		fromDir := strings.Split(from, "/")
+		target := strings.Split(file.OriginalName, "/")
+		return (&url.URL{Path: relativePath(fromDir[:len(fromDir)-1], target)}).EscapedPath()
+	case LinksRef:
+		return fmt.Sprintf(`{{< ref "%s" >}}`, path.Join("/", options.LinkSection, file.OriginalName))
+	}
+
+	pageURL, _ := file.metadata["url"].(string)
+	switch {
+	case options.LinkStrategy == LinksAbsolute && pageURL != "":
+		return strings.TrimSuffix(options.BaseURL, "/") + "/" + strings.TrimPrefix(pageURL, "/")
+	case options.LinkStrategy == LinksAbsolute:
+		return strings.TrimSuffix(options.BaseURL, "/") + "/" + sitePath(file, options)
+	case pageURL != "":
+		return pageURL
+	case options.LinkStrategy == LinksRoot || hasOwnURL(pages.page(from)):
+		return "/" + sitePath(file, options)
+	}
+	return relativeHugoLink(from, pagePath(file), options)
+}
+
+// hasOwnURL reports whether the page's URL is set in its frontmatter.
+func hasOwnURL(file *markdownFile) bool {
+	if file == nil {
+		return false
+	}
+	pageURL, _ := file.metadata["url"].(string)
+	return pageURL != ""
+}
+
+// sitePath is the path of the page for the file from the root of the site, without the
+// leading slash. The pages are in the section given by LinkSection, and their URLs end
+// with a slash or (with UglyURLs) .html.
+func sitePath(file *markdownFile, options *Options) string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(options.LinkSection, "/") {
+		if segment != "" {
+			segments = append(segments, urlize(segment))
+		}
+	}
+	sitePath := strings.Join(append(segments, pagePath(file)...), "/")
+	if options.UglyURLs {
+		return sitePath + ".html"
+	}
+	return sitePath + "/"
+}
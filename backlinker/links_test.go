@@ -0,0 +1,94 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkStrategies(t *testing.T) {
+	notes := map[string]string{
+		"journal/Today.md":      "Worked on [[Big Ideas#Next steps]]\n",
+		"projects/Big Ideas.md": "---\nslug: ideas\n---\nThe plan\n",
+	}
+	tests := []struct {
+		strategy string
+		ugly     bool
+		link     string
+		backlink string
+	}{
+		{LinksRelative, false, "../../projects/ideas/#next-steps", "../../journal/today/"},
+		{LinksRoot, false, "/notes/projects/ideas/#next-steps", "/notes/journal/today/"},
+		{LinksRoot, true, "/notes/projects/ideas.html#next-steps", "/notes/journal/today.html"},
+		{LinksAbsolute, false, "https://example.com/notes/projects/ideas/#next-steps",
+			"https://example.com/notes/journal/today/"},
+		{LinksMarkdown, false, "../projects/Big%20Ideas.md#next-steps", "../journal/Today.md"},
+		{LinksRef, false, `{{< ref "/notes/projects/Big Ideas.md" >}}#next-steps`,
+			`{{< ref "/notes/journal/Today.md" >}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			require := require.New(t)
+			sourceDir := writeTestFiles(t, notes)
+			defer os.RemoveAll(sourceDir)
+			destDir := writeTestFiles(t, nil)
+			defer os.RemoveAll(destDir)
+			options := testBuildOptions(sourceDir, destDir)
+			options.LinkStrategy = tt.strategy
+			options.LinkSection = "/notes/"
+			options.BaseURL = "https://example.com/"
+			options.UglyURLs = tt.ugly
+
+			require.Nil(ProcessBackLinks(options))
+			today, err := ioutil.ReadFile(filepath.Join(destDir, "journal", "Today.md"))
+			require.Nil(err)
+			require.Contains(string(today), "Worked on [Big Ideas#Next steps]("+tt.link+")\n")
+			ideas, err := ioutil.ReadFile(filepath.Join(destDir, "projects", "Big Ideas.md"))
+			require.Nil(err)
+			require.Contains(string(ideas), "* [Today]("+tt.backlink+")\n")
+		})
+	}
+}
+
+func TestPageURLOverride(t *testing.T) {
+	require := require.New(t)
+	file := createMarkdownFile("About.md", false, testOptions)
+	file.metadata["url"] = "/about-us/"
+	options := testBuildOptions("content", "dest")
+	pages := newPageIndex(map[string]*markdownFile{"about.md": file})
+	require.Equal("/about-us/", pageLink("Notes.md", file, pages, options))
+	options.LinkStrategy = LinksAbsolute
+	options.BaseURL = "https://example.com"
+	require.Equal("https://example.com/about-us/", pageLink("Notes.md", file, pages, options))
+	options.BaseURL = ""
+	require.NotNil(options.Validate())
+	options.LinkStrategy = "sideways"
+	require.NotNil(options.Validate())
+}
+
+func TestLinksFromPageWithURL(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Deep.md":  "---\nurl: /x/deep/page/\n---\nSee [[Other]]\n",
+		"Other.md": "Back to [[Deep]] and [[Third]]\n",
+		"Third.md": "Nothing here\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(err)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+	options.LinkSection = "notes"
+
+	require.Nil(ProcessBackLinks(options))
+	deep, err := ioutil.ReadFile(filepath.Join(destDir, "Deep.md"))
+	require.Nil(err)
+	require.Contains(string(deep), "See [Other](/notes/other/)\n")
+	require.Contains(string(deep), "* [Other](/notes/other/)\n")
+	other, err := ioutil.ReadFile(filepath.Join(destDir, "Other.md"))
+	require.Nil(err)
+	require.Contains(string(other), "Back to [Deep](/x/deep/page/) and [Third](../third/)\n")
+}
@@ -0,0 +1,131 @@
+package backlinker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// localGraphNode is a page in the neighborhood of another page. Depth is the number of
+// links between them, ignoring the direction of the links.
+type localGraphNode struct {
+	graphNode
+	Depth int `json:"depth"`
+}
+
+// localGraph is the part of the note graph around a single page, for drawing a graph
+// of the page's neighborhood (like Obsidian's local graph).
+type localGraph struct {
+	Nodes []localGraphNode `json:"nodes"`
+	Edges []graphEdge      `json:"links"`
+}
+
+// neighbors finds the pages that each page links to or is linked from.
+func neighbors(graph *noteGraph) map[string][]string {
+	result := make(map[string][]string)
+	seen := make(map[[2]string]bool)
+	for _, edge := range graph.Edges {
+		if edge.Source == edge.Target || seen[[2]string{edge.Source, edge.Target}] {
+			continue
+		}
+		seen[[2]string{edge.Source, edge.Target}] = true
+		seen[[2]string{edge.Target, edge.Source}] = true
+		result[edge.Source] = append(result[edge.Source], edge.Target)
+		result[edge.Target] = append(result[edge.Target], edge.Source)
+	}
+	for _, ids := range result {
+		sort.Strings(ids)
+	}
+	return result
+}
+
+// buildLocalGraph gathers the pages that are at most depth links away from the page with
+// the id center, following links in both directions. The closest pages are taken first,
+// until there are maxNodes of them. Every link between the pages is included.
+func buildLocalGraph(graph *noteGraph, adjacent map[string][]string, nodes map[string]graphNode,
+	center string, depth int, maxNodes int) *localGraph {
+	depths := map[string]int{center: 0}
+	local := &localGraph{
+		Nodes: []localGraphNode{{graphNode: nodes[center]}},
+		Edges: make([]graphEdge, 0),
+	}
+	level := []string{center}
+	for distance := 1; distance <= depth && len(level) > 0; distance++ {
+		next := make([]string, 0)
+		for _, id := range level {
+			for _, other := range adjacent[id] {
+				if _, found := depths[other]; found || len(local.Nodes) >= maxNodes {
+					continue
+				}
+				depths[other] = distance
+				local.Nodes = append(local.Nodes, localGraphNode{graphNode: nodes[other], Depth: distance})
+				next = append(next, other)
+			}
+		}
+		level = next
+	}
+	for _, edge := range graph.Edges {
+		_, hasSource := depths[edge.Source]
+		_, hasTarget := depths[edge.Target]
+		if hasSource && hasTarget {
+			local.Edges = append(local.Edges, edge)
+		}
+	}
+	return local
+}
+
+// writeLocalGraphs writes the neighborhood of each published page to a JSON file in
+// options.LocalGraphs, which is meant to be in Hugo's data directory. The files are named
+// after the path of the page's URL, so the theme can find them from the page. Like the
+// pages, the files are only written when they change, and the directory has a manifest
+// of its own (see ManifestFile), so that the files for pages which were removed or made
+// private are removed too.
+func writeLocalGraphs(fileMap map[string]*markdownFile, options *Options) error {
+	if options.LocalGraphs == "" {
+		return nil
+	}
+	previous, err := loadManifest(options.LocalGraphs)
+	if err != nil {
+		return err
+	}
+	graph := buildGraph(fileMap)
+	adjacent := neighbors(graph)
+	nodes := make(map[string]graphNode, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = node
+	}
+	generated := make(manifest)
+	for _, file := range fileMap {
+		if file.IsPrivate {
+			continue
+		}
+		local := buildLocalGraph(graph, adjacent, nodes, file.OriginalName, options.LocalGraphDepth,
+			options.LocalGraphMaxNodes)
+		data, err := json.MarshalIndent(local, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		name := strings.Join(pagePath(file), "/") + ".json"
+		generated[name] = contentHash(data)
+		filename, err := destPath(options.LocalGraphs, name)
+		if err != nil {
+			return err
+		}
+		existing, err := ioutil.ReadFile(filename)
+		if err == nil && bytes.Equal(existing, data) {
+			continue
+		}
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	err = removeStaleFiles(options.LocalGraphs, previous, generated)
+	if err != nil {
+		return err
+	}
+	return saveManifest(options.LocalGraphs, generated)
+}
@@ -0,0 +1,97 @@
+package backlinker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalGraphs(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"A.md":          "Links to [[B]]\n",
+		"B.md":          "Links to [[C]] and back to [[A]]\n",
+		"C.md":          "Links to [[D]]\n",
+		"projects/E.md": "Links to [[A]]\n",
+		"Secret.md":     "---\nprivate: true\n---\nLinks to [[A]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, filepath.Join(destDir, "content"))
+	require.Nil(os.Mkdir(options.Dest, 0755))
+	options.LocalGraphs = filepath.Join(destDir, "data", "graphs")
+
+	require.Nil(ProcessBackLinks(options))
+	data, err := ioutil.ReadFile(filepath.Join(options.LocalGraphs, "a.json"))
+	require.Nil(err)
+	var local localGraph
+	require.Nil(json.Unmarshal(data, &local))
+	nodes := make(map[string]int)
+	for _, node := range local.Nodes {
+		nodes[node.ID] = node.Depth
+	}
+	require.Equal(map[string]int{"A.md": 0, "B.md": 1, "projects/E.md": 1, "C.md": 2}, nodes)
+	require.Equal("A", local.Nodes[0].Title)
+	require.Len(local.Edges, 4)
+	_, err = os.Stat(filepath.Join(options.LocalGraphs, "projects", "e.json"))
+	require.Nil(err)
+	_, err = os.Stat(filepath.Join(options.LocalGraphs, "secret.json"))
+	require.True(os.IsNotExist(err))
+
+	fileMap, err := createFileMapping([]string{"A.md", "B.md", "C.md", "projects/E.md"}, options)
+	require.Nil(err)
+	_, err = collectBacklinks(sourceDir, fileMap, newBuildCache(), options)
+	require.Nil(err)
+	graph := buildGraph(fileMap)
+	all := make(map[string]graphNode)
+	for _, node := range graph.Nodes {
+		all[node.ID] = node
+	}
+	limited := buildLocalGraph(graph, neighbors(graph), all, "A.md", 2, 2)
+	require.Len(limited.Nodes, 2)
+	require.Equal("B.md", limited.Nodes[1].ID)
+	require.Len(limited.Edges, 2)
+}
+
+func TestLocalGraphsRemovesStaleFiles(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Plan.md":  "Secret plan: see [[Other]]\n",
+		"Gone.md":  "Links to [[Other]]\n",
+		"Other.md": "Nothing here\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, filepath.Join(destDir, "content"))
+	options.LocalGraphs = filepath.Join(destDir, "graphs")
+
+	require.Nil(ProcessBackLinks(options))
+	for _, name := range []string{"plan.json", "gone.json", "other.json"} {
+		_, err := os.Stat(filepath.Join(options.LocalGraphs, name))
+		require.Nil(err, name)
+	}
+	other, err := ioutil.ReadFile(filepath.Join(options.LocalGraphs, "other.json"))
+	require.Nil(err)
+	require.Contains(string(other), "Secret plan")
+
+	require.Nil(os.Remove(filepath.Join(sourceDir, "Gone.md")))
+	require.Nil(ioutil.WriteFile(filepath.Join(sourceDir, "Plan.md"),
+		[]byte("---\nprivate: true\n---\nSecret plan: see [[Other]]\n"), 0644))
+	require.Nil(ProcessBackLinks(options))
+	_, err = os.Stat(filepath.Join(options.LocalGraphs, "gone.json"))
+	require.True(os.IsNotExist(err), "The graph of a deleted note should be removed")
+	_, err = os.Stat(filepath.Join(options.LocalGraphs, "plan.json"))
+	require.True(os.IsNotExist(err), "The graph of a private note should be removed")
+	other, err = ioutil.ReadFile(filepath.Join(options.LocalGraphs, "other.json"))
+	require.Nil(err)
+	require.NotContains(string(other), "Secret plan")
+
+	options.LocalGraphs = options.Dest
+	require.NotNil(options.Validate())
+}
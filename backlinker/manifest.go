@@ -0,0 +1,143 @@
+package backlinker
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFile lists the files that sharedbrain wrote to the destination directory, so
+// that the ones which are no longer generated can be removed by the next build.
+const ManifestFile = ".sharedbrain-manifest"
+
+// manifest maps the slash separated name of each generated file to the hash of the
+// contents that were written.
+type manifest map[string]string
+
+// newManifest describes the files that are about to be written for fileMap.
+func newManifest(fileMap map[string]*markdownFile) manifest {
+	result := make(manifest, len(fileMap))
+	for _, file := range fileMap {
+		if file.IsPrivate {
+			continue
+		}
+		result[file.OriginalName] = contentHash(file.newData.Bytes())
+	}
+	return result
+}
+
+// loadManifest reads the manifest from the last build in destDir. Without one, no file
+// is known to have been generated, so nothing will be removed.
+func loadManifest(destDir string) (manifest, error) {
+	result := make(manifest)
+	data, err := ioutil.ReadFile(filepath.Join(destDir, ManifestFile))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unable to read %s: bad line %q", ManifestFile, line)
+		}
+		result[fields[1]] = fields[0]
+	}
+	return result, scanner.Err()
+}
+
+// saveManifest writes the manifest to destDir, in the same format as sha256sum uses.
+func saveManifest(destDir string, generated manifest) error {
+	names := make([]string, 0, len(generated))
+	for name := range generated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var data bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&data, "%s  %s\n", generated[name], name)
+	}
+	filename, err := destPath(destDir, ManifestFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data.Bytes(), 0644)
+}
+
+// removeStaleFiles deletes the files that the previous build generated but the current
+// one doesn't. A file is only deleted if it still has the contents that sharedbrain
+// wrote, so files that were edited or replaced by someone else are left alone.
+// Directories that are left empty are removed as well.
+func removeStaleFiles(destDir string, previous manifest, current manifest) error {
+	removed := 0
+	for name, hash := range previous {
+		if _, exists := current[name]; exists {
+			continue
+		}
+		filename, err := joinDest(destDir, name)
+		if err != nil {
+			return err
+		}
+		err = checkParentWithin(destDir, filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			log.Printf("Leaving %s in place, because it isn't a regular file\n", filename)
+			continue
+		}
+		existing, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if contentHash(existing) != hash {
+			log.Printf("Leaving %s in place, because it was changed after it was generated\n", filename)
+			continue
+		}
+		err = os.Remove(filename)
+		if err != nil {
+			return err
+		}
+		removeEmptyDirs(destDir, filepath.Dir(filename))
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Removed %d stale files\n", removed)
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents, up to but not including destDir, for as
+// long as they are empty.
+func removeEmptyDirs(destDir string, dir string) {
+	destDir = filepath.Clean(destDir)
+	for isWithin(destDir, dir) {
+		// Remove fails on directories which aren't empty
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
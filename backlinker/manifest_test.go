@@ -0,0 +1,101 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveStaleFiles(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md":             "See [[Phoenix]] and [[Someone]]\n",
+		"projects/Phoenix.md": "The project\n",
+		"journal/Old.md":      "Old news\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, map[string]string{
+		"_index.md":         "Written by hand\n",
+		"journal/keep.txt":  "Also not ours\n",
+		"projects/Extra.md": "Someone else's page\n",
+	})
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	generated, err := loadManifest(destDir)
+	require.Nil(err)
+	require.Len(generated, 4)
+	require.Contains(generated, "projects/Phoenix.md")
+	require.Contains(generated, "Someone.md")
+	require.NotContains(generated, "_index.md")
+
+	// Phoenix moves, Old is deleted and Someone loses its only backlink
+	require.Nil(os.Rename(filepath.Join(sourceDir, "projects", "Phoenix.md"),
+		filepath.Join(sourceDir, "Phoenix.md")))
+	require.Nil(os.Remove(filepath.Join(sourceDir, "journal", "Old.md")))
+	require.Nil(ioutil.WriteFile(filepath.Join(sourceDir, "Home.md"), []byte("See [[Phoenix]]\n"), 0644))
+	require.Nil(ProcessBackLinks(options))
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(name)))
+		return err == nil
+	}
+	require.True(exists("Phoenix.md"))
+	require.False(exists("projects/Phoenix.md"))
+	require.False(exists("Someone.md"), "Phantom pages without backlinks are removed")
+	require.False(exists("journal/Old.md"))
+	require.True(exists("_index.md"), "Files that sharedbrain didn't write are kept")
+	require.True(exists("journal/keep.txt"))
+	require.True(exists("projects/Extra.md"))
+}
+
+func TestRemoveStaleFilesKeepsChangedFiles(t *testing.T) {
+	require := require.New(t)
+	destDir := writeTestFiles(t, map[string]string{
+		"Edited.md":       "Changed by hand\n",
+		"Generated.md":    "As generated\n",
+		"folder/Alone.md": "As generated\n",
+	})
+	defer os.RemoveAll(destDir)
+	previous := manifest{
+		"Edited.md":       contentHash([]byte("As generated\n")),
+		"Generated.md":    contentHash([]byte("As generated\n")),
+		"folder/Alone.md": contentHash([]byte("As generated\n")),
+		"Missing.md":      contentHash([]byte("As generated\n")),
+		"../Outside.md":   contentHash([]byte("As generated\n")),
+	}
+	err := removeStaleFiles(destDir, previous, manifest{})
+	require.NotNil(err, "Names outside of the destination are refused")
+
+	delete(previous, "../Outside.md")
+	require.Nil(removeStaleFiles(destDir, previous, manifest{}))
+	_, err = os.Stat(filepath.Join(destDir, "Edited.md"))
+	require.Nil(err, "Files changed since they were generated are kept")
+	_, err = os.Stat(filepath.Join(destDir, "Generated.md"))
+	require.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(destDir, "folder"))
+	require.True(os.IsNotExist(err), "Empty directories are removed")
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	require := require.New(t)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	empty, err := loadManifest(destDir)
+	require.Nil(err)
+	require.Empty(empty, "There's no manifest before the first build")
+
+	generated := manifest{
+		"Top.md":                  contentHash([]byte("top")),
+		"projects/Two  spaces.md": contentHash([]byte("two")),
+	}
+	require.Nil(saveManifest(destDir, generated))
+	loaded, err := loadManifest(destDir)
+	require.Nil(err)
+	require.Equal(generated, loaded)
+}
@@ -0,0 +1,133 @@
+package backlinker
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// minMentionLength keeps very short titles from being found all over the place.
+const minMentionLength = 3
+
+// textRun is plain text from the markdown, gathered from consecutive text nodes.
+type textRun struct {
+	text string
+	// stop is the offset in the source just past the run, or -1 if nothing more can be
+	// added to it.
+	stop int
+	node ast.Node
+}
+
+// plainTextRuns finds the text in the markdown that isn't part of a link (including
+// wikilinks, #tags and block references), code or HTML, which is where unlinked mentions
+// can be. Text that goldmark splits into several nodes is put back together, but a line
+// break ends a run.
+func plainTextRuns(root ast.Node, source []byte) []textRun {
+	var runs []textRun
+	ast.Walk(root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node.Kind() {
+		case ast.KindLink, ast.KindAutoLink, ast.KindImage, ast.KindCodeSpan, ast.KindRawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		if _, isWikilink := node.AttributeString(wikilinkAttribute); isWikilink {
+			return ast.WalkSkipChildren, nil
+		}
+		text, isText := node.(*ast.Text)
+		if !isText {
+			return ast.WalkContinue, nil
+		}
+		value := string(text.Segment.Value(source))
+		last := len(runs) - 1
+		if value == "" {
+			// goldmark uses empty text nodes to hold line breaks after other inlines
+			if last >= 0 && (text.SoftLineBreak() || text.HardLineBreak()) {
+				runs[last].stop = -1
+			}
+			return ast.WalkContinue, nil
+		}
+		if last >= 0 && runs[last].stop == text.Segment.Start {
+			runs[last].text += value
+			runs[last].stop = text.Segment.Stop
+		} else {
+			runs = append(runs, textRun{text: value, stop: text.Segment.Stop, node: node})
+		}
+		if text.SoftLineBreak() || text.HardLineBreak() {
+			runs[len(runs)-1].stop = -1
+		}
+		return ast.WalkContinue, nil
+	})
+	return runs
+}
+
+// isWordBoundary reports whether the position in the text is at the edge of a word.
+func isWordBoundary(text []rune, position int) bool {
+	if position <= 0 || position >= len(text) {
+		return true
+	}
+	before := text[position-1]
+	after := text[position]
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return !isWord(before) || !isWord(after)
+}
+
+// findUnlinkedMentions looks for the titles (and aliases) of pages in the plain text of
+// the other notes, and records them as unlinked mentions of the page. All of the titles are found
+// in a single pass over each note, so this works for vaults with thousands of pages.
+// A page is mentioned at most once by each block of another note.
+func findUnlinkedMentions(fileMap map[string]*markdownFile) {
+	patterns := make([]string, 0, len(fileMap))
+	pages := make([][]*markdownFile, 0, len(fileMap))
+	patternIndex := make(map[string]int)
+	for _, file := range fileMap {
+		file.mentions = nil
+		if file.IsPrivate {
+			continue
+		}
+		for _, name := range append([]string{file.Title}, pageAliases(file)...) {
+			if utf8.RuneCountInString(name) < minMentionLength {
+				continue
+			}
+			name = strings.ToLower(name)
+			index, exists := patternIndex[name]
+			if !exists {
+				index = len(patterns)
+				patternIndex[name] = index
+				patterns = append(patterns, name)
+				pages = append(pages, nil)
+			}
+			pages[index] = append(pages[index], file)
+		}
+	}
+	titles := newMatcher(patterns)
+
+	for _, file := range sourceFiles(fileMap) {
+		if file.IsPrivate {
+			continue
+		}
+		for _, run := range plainTextRuns(parseMarkdown(file.body), file.body) {
+			text := []rune(strings.ToLower(run.text))
+			mentioned := make(map[*markdownFile]bool)
+			for _, found := range titles.find(text) {
+				if !isWordBoundary(text, found.Start) || !isWordBoundary(text, found.End) {
+					continue
+				}
+				for _, page := range pages[found.Pattern] {
+					if page == file || mentioned[page] {
+						continue
+					}
+					mentioned[page] = true
+					page.mentions = append(page.mentions, backlink{
+						OtherFile: file,
+						Context:   blockContext(run.node, file.body),
+						Kind:      mentionBacklink,
+					})
+				}
+			}
+		}
+	}
+}
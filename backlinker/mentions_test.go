@@ -0,0 +1,56 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlainTextRuns(t *testing.T) {
+	require := require.New(t)
+	source := []byte("Talking about Big Ideas and [[Big Ideas]], `Big Ideas`\nand [Big Ideas](x) *here*\n")
+	runs := plainTextRuns(parseMarkdown(source), source)
+	texts := make([]string, 0, len(runs))
+	for _, run := range runs {
+		texts = append(texts, run.text)
+	}
+	require.Equal([]string{"Talking about Big Ideas and ", ", ", "and ", " ", "here"}, texts)
+}
+
+func TestUnlinkedMentions(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Big Ideas.md": "Mentions itself: Big Ideas\n",
+		"Journal.md":   "Thinking about big ideas today. More big ideas!\n\nNot `Big Ideas` or [[Big Ideas]]\n",
+		"Other.md":     "BigIdeas and Big Ideasy are not mentions, nor is Journaling\n",
+		"Secret.md":    "---\nprivate: true\n---\nBig Ideas\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+	options.UnlinkedMentions = true
+
+	require.Nil(ProcessBackLinks(options))
+	output, err := ioutil.ReadFile(filepath.Join(destDir, "Big Ideas.md"))
+	require.Nil(err)
+	require.Contains(string(output), "## Backlinks\n\n* [Journal](../journal/)\n    * Not `Big Ideas` or [Big Ideas](../big-ideas/)\n"+
+		"\n## Unlinked mentions\n\n* [Journal](../journal/)\n    * Thinking about big ideas today. More big ideas!\n")
+	require.Equal(2, strings.Count(string(output), "* [Journal]("), "Once in each section")
+	require.NotContains(string(output), "Other")
+	require.NotContains(string(output), "Secret")
+
+	journal, err := ioutil.ReadFile(filepath.Join(destDir, "Journal.md"))
+	require.Nil(err)
+	require.NotContains(string(journal), "Unlinked mentions")
+
+	options.BacklinksMode = BacklinksFrontmatter
+	require.Nil(ProcessBackLinks(options))
+	output, err = ioutil.ReadFile(filepath.Join(destDir, "Big Ideas.md"))
+	require.Nil(err)
+	require.Contains(string(output), "[[unlinked_mentions]]")
+}
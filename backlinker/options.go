@@ -0,0 +1,280 @@
+package backlinker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/naoina/toml"
+)
+
+// ConfigFile is the name of the project configuration file that is used when no other
+// file is given.
+const ConfigFile = "sharedbrain.toml"
+
+// These are the ways that backlinks can be added to a page: as a markdown section at
+// the end of the page, or as data in the frontmatter for the Hugo theme to render.
+const (
+	BacklinksMarkdown    = "markdown"
+	BacklinksFrontmatter = "frontmatter"
+)
+
+// linkPathPlaceholder is replaced in Options.LinkFormat with the path to the page.
+const linkPathPlaceholder = "{path}"
+
+// Options holds all of the settings for a build. Each one can be set in the
+// configuration file (using the name in its toml tag) or on the command line.
+type Options struct {
+	// Content is the directory with the source markdown files.
+	Content string `toml:"content"`
+	// Dest is the directory where the processed files are written.
+	Dest string `toml:"dest"`
+	// FrontmatterFormat is the format of the generated frontmatter
+	// (see ValidFrontmatterFormat).
+	FrontmatterFormat string `toml:"frontmatter_format"`
+	// BacklinksHeading is the markdown line that starts the backlinks section.
+	BacklinksHeading string `toml:"backlinks_heading"`
+	// DailyNotePattern is a regular expression matched against filenames to find
+	// daily notes, which get their title and date from the filename.
+	DailyNotePattern string `toml:"daily_note_pattern"`
+	// DailyNoteTime is the time of day (with time zone) given to daily notes.
+	DailyNoteTime string `toml:"daily_note_time"`
+	// LinkFormat is the format of links between pages. {path} is replaced with the path
+	// to the other page, relative to the folder of the page the link appears on.
+	// It's only used by the LinksRelative strategy.
+	LinkFormat string `toml:"link_format"`
+	// LinkStrategy is how links between pages are written: LinksRelative, LinksRoot,
+	// LinksAbsolute, LinksMarkdown or LinksRef (see pageLink).
+	LinkStrategy string `toml:"link_strategy"`
+	// LinkSection is the path of the Hugo section that the pages are in, like "notes",
+	// for root and absolute links and refs.
+	LinkSection string `toml:"link_section"`
+	// BaseURL is the start of absolute links, like "https://example.com/".
+	BaseURL string `toml:"base_url"`
+	// UglyURLs makes root and absolute links end in .html, to match Hugo's uglyURLs.
+	UglyURLs bool `toml:"ugly_urls"`
+	// BacklinksTemplate is a Go text/template file used to render the backlinks
+	// section. The default template produces a bulleted list.
+	BacklinksTemplate string `toml:"backlinks_template"`
+	// BacklinksMode is where the backlinks go: BacklinksMarkdown or BacklinksFrontmatter.
+	BacklinksMode string `toml:"backlinks_mode"`
+	// Cache keeps what was parsed from each file in the destination directory, so that
+	// only the files that changed are parsed in the next build.
+	Cache bool `toml:"cache"`
+	// WatchDelay is how long Watch waits for things to settle down after a change before
+	// it rebuilds, as a Go duration (like "300ms").
+	WatchDelay string `toml:"watch_delay"`
+	// BlockAnchor is the markdown added to the end of a block with a ^block-id, so that
+	// links can point to it. {id} is replaced with the anchor.
+	BlockAnchor string `toml:"block_anchor"`
+	// Hashtags is how #tags are used: HashtagsNone, HashtagsPages, HashtagsTaxonomy or
+	// HashtagsBoth.
+	Hashtags string `toml:"hashtags"`
+	// EmbedDepth is how deeply embeds (![[Page]]) can be nested in each other.
+	EmbedDepth int `toml:"embed_depth"`
+	// Publish is the publishing policy, PublishAll or PublishMarked (see isPrivate).
+	Publish string `toml:"publish"`
+	// PublishTags mark notes as public when the policy is PublishMarked.
+	PublishTags []string `toml:"publish_tags"`
+	// PrivateTags mark notes as private, whatever the policy.
+	PrivateTags []string `toml:"private_tags"`
+	// PublishDrafts publishes the notes with draft = true in their frontmatter.
+	PublishDrafts bool `toml:"publish_drafts"`
+	// CheckBrokenLinks, CheckOrphans and CheckNearDuplicates are the severities of the
+	// rules used by Check: SeverityError, SeverityWarning or SeverityIgnore.
+	CheckBrokenLinks    string `toml:"check_broken_links"`
+	CheckOrphans        string `toml:"check_orphans"`
+	CheckNearDuplicates string `toml:"check_near_duplicates"`
+	// UnlinkedMentions adds the places where other notes have the title of a page
+	// without linking to it, in a section after the backlinks.
+	UnlinkedMentions bool `toml:"unlinked_mentions"`
+	// UnlinkedMentionsHeading is the markdown line that starts the unlinked mentions.
+	UnlinkedMentionsHeading string `toml:"unlinked_mentions_heading"`
+	// GraphOutput is a file that each build writes the graph of the notes to, in the
+	// format given by its extension: .json, .graphml, or .dot (see Graph).
+	GraphOutput string `toml:"graph_output"`
+	// LocalGraphs is a directory (usually in Hugo's data directory) where each build
+	// writes the neighborhood of each page in the graph, as JSON.
+	LocalGraphs string `toml:"local_graphs"`
+	// LocalGraphDepth is how many links away from a page its neighborhood goes.
+	LocalGraphDepth int `toml:"local_graph_depth"`
+	// LocalGraphMaxNodes is the most pages that a neighborhood has, including the page.
+	LocalGraphMaxNodes int `toml:"local_graph_max_nodes"`
+
+	dailyNoteRegexp   *regexp.Regexp
+	backlinksTemplate *template.Template
+}
+
+// DefaultOptions returns the options used when nothing else has been configured.
+func DefaultOptions() *Options {
+	options := &Options{
+		FrontmatterFormat: FrontmatterTOML,
+		BacklinksHeading:  "## Backlinks",
+		DailyNotePattern:  `\d\d\d\d-\d\d-\d\d.md`,
+		DailyNoteTime:     "08:00:00Z",
+		LinkFormat:        "../" + linkPathPlaceholder + "/",
+		LinkStrategy:      LinksRelative,
+		BacklinksMode:     BacklinksMarkdown,
+		Cache:             true,
+		WatchDelay:        "300ms",
+		BlockAnchor:       `<span id="` + blockIDPlaceholder + `"></span>`,
+		Hashtags:          HashtagsNone,
+		EmbedDepth:        5,
+		Publish:           PublishAll,
+		PublishTags:       []string{"public"},
+		PrivateTags:       []string{"private"},
+
+		CheckBrokenLinks:    SeverityError,
+		CheckOrphans:        SeverityWarning,
+		CheckNearDuplicates: SeverityWarning,
+
+		UnlinkedMentionsHeading: "## Unlinked mentions",
+		LocalGraphDepth:         2,
+		LocalGraphMaxNodes:      50,
+	}
+	options.dailyNoteRegexp = regexp.MustCompile(options.DailyNotePattern)
+	options.backlinksTemplate = template.Must(loadBacklinksTemplate(""))
+	return options
+}
+
+// LoadOptions reads the configuration file into options. Settings that aren't in the
+// file are left alone. Relative paths in the file (for the content and dest directories,
+// the backlinks template and the graph outputs) are relative to the file itself, so that
+// a project can be checked in and built from anywhere.
+func LoadOptions(filename string, options *Options) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	paths := []*string{&options.Content, &options.Dest, &options.BacklinksTemplate, &options.GraphOutput,
+		&options.LocalGraphs}
+	previous := make([]string, len(paths))
+	for i, setting := range paths {
+		previous[i] = *setting
+		*setting = ""
+	}
+	err = toml.Unmarshal(data, options)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	configDir := filepath.Dir(filename)
+	for i, setting := range paths {
+		if *setting == "" {
+			*setting = previous[i]
+		} else if !filepath.IsAbs(*setting) {
+			*setting = filepath.Join(configDir, *setting)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the options are complete and usable for a build. It needs to be
+// called after the options are changed.
+func (options *Options) Validate() error {
+	if options.Dest == "" {
+		return fmt.Errorf("either dest or content have not been set")
+	}
+	err := options.validateReading()
+	if err != nil {
+		return err
+	}
+	if options.GraphOutput != "" && graphFormatForFile(options.GraphOutput) == "" {
+		return fmt.Errorf("unknown graph format for %s (use .json, .graphml or .dot)", options.GraphOutput)
+	}
+	if options.LocalGraphs != "" && filepath.Clean(options.LocalGraphs) == filepath.Clean(options.Dest) {
+		// Each of them has its own manifest
+		return fmt.Errorf("local graphs can't be written to the destination directory")
+	}
+	if options.LocalGraphDepth < 1 || options.LocalGraphMaxNodes < 1 {
+		return fmt.Errorf("local graph depth and maximum nodes must be at least 1")
+	}
+	return nil
+}
+
+// validateReading checks the options that are needed to read the notes, which is all of
+// them except for the ones about what a build writes. Commands that don't write the site
+// (like Check) don't need a destination directory.
+func (options *Options) validateReading() error {
+	if options.Content == "" {
+		return fmt.Errorf("content has not been set")
+	}
+	if !ValidFrontmatterFormat(options.FrontmatterFormat) {
+		return fmt.Errorf("unknown frontmatter format %q", options.FrontmatterFormat)
+	}
+	dailyNoteRegexp, err := regexp.Compile(options.DailyNotePattern)
+	if err != nil {
+		return fmt.Errorf("invalid daily note pattern: %v", err)
+	}
+	options.dailyNoteRegexp = dailyNoteRegexp
+	_, err = time.Parse(time.RFC3339, "2020-04-26T"+options.DailyNoteTime)
+	if err != nil {
+		return fmt.Errorf("invalid daily note time %q: %v", options.DailyNoteTime, err)
+	}
+	if !strings.Contains(options.LinkFormat, linkPathPlaceholder) {
+		return fmt.Errorf("link format %q does not contain %s", options.LinkFormat, linkPathPlaceholder)
+	}
+	if !validLinkStrategy(options.LinkStrategy) {
+		return fmt.Errorf("unknown link strategy %q", options.LinkStrategy)
+	}
+	if options.LinkStrategy == LinksAbsolute && options.BaseURL == "" {
+		return fmt.Errorf("absolute links need a base URL")
+	}
+	if options.BacklinksMode != BacklinksMarkdown && options.BacklinksMode != BacklinksFrontmatter {
+		return fmt.Errorf("unknown backlinks mode %q", options.BacklinksMode)
+	}
+	_, err = time.ParseDuration(options.WatchDelay)
+	if err != nil {
+		return fmt.Errorf("invalid watch delay: %v", err)
+	}
+	if !strings.Contains(options.BlockAnchor, blockIDPlaceholder) {
+		return fmt.Errorf("block anchor %q does not contain %s", options.BlockAnchor, blockIDPlaceholder)
+	}
+	if options.Hashtags != HashtagsNone && !options.tagPages() && !options.tagTaxonomy() {
+		return fmt.Errorf("unknown hashtags setting %q", options.Hashtags)
+	}
+	if options.EmbedDepth < 1 {
+		return fmt.Errorf("embed depth must be at least 1")
+	}
+	if options.Publish != PublishAll && options.Publish != PublishMarked {
+		return fmt.Errorf("unknown publishing policy %q", options.Publish)
+	}
+	for rule, severity := range map[string]string{
+		RuleBrokenLinks:    options.CheckBrokenLinks,
+		RuleOrphans:        options.CheckOrphans,
+		RuleNearDuplicates: options.CheckNearDuplicates,
+	} {
+		if !validSeverity(severity) {
+			return fmt.Errorf("unknown severity %q for check_%s", severity, rule)
+		}
+	}
+	backlinksTemplate, err := loadBacklinksTemplate(options.BacklinksTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid backlinks template: %v", err)
+	}
+	options.backlinksTemplate = backlinksTemplate
+	return nil
+}
+
+// isDailyNote reports whether the file is a daily note, based on its name.
+func (options *Options) isDailyNote(filename string) bool {
+	return options.dailyNoteRegexp.MatchString(filename)
+}
+
+// dailyNoteDate is the date given to a daily note with the given page name.
+func (options *Options) dailyNoteDate(name string) (time.Time, error) {
+	return time.Parse(time.RFC3339, name+"T"+options.DailyNoteTime)
+}
+
+// formatBlockAnchor fills in the block anchor format with the anchor for a block.
+func (options *Options) formatBlockAnchor(anchor string) string {
+	return strings.Replace(options.BlockAnchor, blockIDPlaceholder, anchor, 1)
+}
+
+// formatLink fills in the link format with the path to another page.
+func (options *Options) formatLink(pagePath string) string {
+	return strings.Replace(options.LinkFormat, linkPathPlaceholder, pagePath, 1)
+}
@@ -0,0 +1,100 @@
+package backlinker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOptions(t *testing.T) {
+	require := require.New(t)
+	dir := writeTestFiles(t, map[string]string{
+		"site/sharedbrain.toml": `content = "../notes"
+dest = "/srv/site/content"
+backlinks_heading = "### Linked from"
+backlinks_template = "backlinks.tmpl"
+`,
+		"typo.toml": `backlink_heading = "Oops"`,
+	})
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.Dest = "overridden"
+	err := LoadOptions(filepath.Join(dir, "site", ConfigFile), options)
+	require.Nil(err)
+	require.Equal(filepath.Join(dir, "notes"), options.Content, "Relative paths are relative to the file")
+	require.Equal("/srv/site/content", options.Dest)
+	require.Equal("### Linked from", options.BacklinksHeading)
+	require.Equal(filepath.Join(dir, "site", "backlinks.tmpl"), options.BacklinksTemplate)
+	require.Equal(FrontmatterTOML, options.FrontmatterFormat, "Settings not in the file are kept")
+
+	err = LoadOptions(filepath.Join(dir, "typo.toml"), DefaultOptions())
+	require.NotNil(err, "Unknown settings should be reported")
+}
+
+func TestValidateOptions(t *testing.T) {
+	require := require.New(t)
+	options := testBuildOptions("content", "dest")
+	require.Nil(options.Validate())
+
+	options = testBuildOptions("content", "")
+	require.NotNil(options.Validate())
+	options = testBuildOptions("content", "dest")
+	options.FrontmatterFormat = "xml"
+	require.NotNil(options.Validate())
+	options = testBuildOptions("content", "dest")
+	options.DailyNotePattern = "("
+	require.NotNil(options.Validate())
+	options = testBuildOptions("content", "dest")
+	options.DailyNoteTime = "8am"
+	require.NotNil(options.Validate())
+	options = testBuildOptions("content", "dest")
+	options.LinkFormat = "../page/"
+	require.NotNil(options.Validate())
+}
+
+func TestCustomOptions(t *testing.T) {
+	require := require.New(t)
+	options := testBuildOptions("content", "dest")
+	options.BacklinksHeading = "### Linked from"
+	options.DailyNotePattern = `^\d\d\d\d_\d\d_\d\d\.md$`
+	options.DailyNoteTime = "12:30:00-04:00"
+	options.LinkFormat = "{path}.html"
+	require.Nil(options.Validate())
+
+	require.Equal("../projects/phoenix.html", createHugoLink("journal/Today.md", "projects/Phoenix.md", options))
+
+	daily := createMarkdownFile("2020_04_26.md", false, options)
+	require.True(daily.IsDateFile)
+	require.False(createMarkdownFile("2020-04-26.md", false, options).IsDateFile)
+	options.DailyNotePattern = `\d\d\d\d-\d\d-\d\d.md`
+	require.Nil(options.Validate())
+	daily = createMarkdownFile("2020-04-26.md", false, options)
+	writer := bytes.Buffer{}
+	require.Nil(adjustFrontmatter(daily, options, &writer))
+	require.Contains(writer.String(), "date = 2020-04-26T12:30:00-04:00")
+
+	fileMap := map[string]*markdownFile{"2020-04-26.md": daily}
+	page := createMarkdownFile("Page.md", false, options)
+	page.BackLinks = append(page.BackLinks, backlink{OtherFile: daily, Context: "About [[Page]]"})
+	writer.Reset()
+	require.Nil(addBacklinks(page, newPageIndex(fileMap), options, &writer))
+	require.Equal(`
+### Linked from
+
+* [2020-04-26](2020-04-26.html)
+    * About [Page](page.html)
+`, writer.String())
+}
+
+func TestProcessBackLinksValidatesOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sharedbrain")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	err = ProcessBackLinks(testBuildOptions(dir, ""))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,96 @@
+package backlinker
+
+import "fmt"
+
+// These are the publishing policies. With PublishAll every note is published unless it
+// is private, and with PublishMarked only the notes that are marked as public are.
+const (
+	PublishAll    = "all"
+	PublishMarked = "marked"
+)
+
+// metadataBool looks up a true/false setting in the metadata. It reports whether the
+// setting is there at all.
+func metadataBool(meta map[string]interface{}, key string) (bool, bool) {
+	value, ok := meta[key].(bool)
+	return value, ok
+}
+
+// metadataStrings looks up a setting which can be either a single string or a list of
+// them, like tags.
+func metadataStrings(meta map[string]interface{}, key string) []string {
+	switch value := meta[key].(type) {
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			result = append(result, fmt.Sprint(item))
+		}
+		return result
+	}
+	return nil
+}
+
+// hasAnyTag reports whether any of the tags in the metadata is one of the given tags.
+func hasAnyTag(meta map[string]interface{}, tags []string) bool {
+	for _, tag := range metadataStrings(meta, "tags") {
+		for _, wanted := range tags {
+			if tag == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPrivate applies the publishing policy to the frontmatter of a note. Notes are kept
+// private by private = true, publish = false, draft = true (unless drafts are published)
+// or one of the private tags. Otherwise, they are public unless the policy is
+// PublishMarked, in which case they also need publish = true or one of the publish tags.
+func isPrivate(meta map[string]interface{}, options *Options) bool {
+	if private, _ := metadataBool(meta, "private"); private {
+		return true
+	}
+	if draft, _ := metadataBool(meta, "draft"); draft && !options.PublishDrafts {
+		return true
+	}
+	publish, hasPublish := metadataBool(meta, "publish")
+	if hasPublish && !publish {
+		return true
+	}
+	if hasAnyTag(meta, options.PrivateTags) {
+		return true
+	}
+	if options.Publish == PublishMarked {
+		return !publish && !hasAnyTag(meta, options.PublishTags)
+	}
+	return false
+}
+
+// applyPublishPolicy marks the private notes and then removes them as sources of
+// backlinks, so that nothing from them shows up on other pages. Pages that were only
+// created because private notes link to them are removed entirely.
+// Private notes stay in the fileMap, so that links to them can be found and shown as
+// plain text (see markdownLink), but they aren't written.
+func applyPublishPolicy(fileMap map[string]*markdownFile, options *Options) {
+	for _, file := range fileMap {
+		if !file.IsNew {
+			file.IsPrivate = isPrivate(file.metadata, options)
+		}
+	}
+	for key, file := range fileMap {
+		kept := file.BackLinks[:0]
+		for _, bl := range file.BackLinks {
+			if !bl.OtherFile.IsPrivate {
+				kept = append(kept, bl)
+			}
+		}
+		file.BackLinks = kept
+		if file.IsNew && len(kept) == 0 {
+			delete(fileMap, key)
+		}
+	}
+}
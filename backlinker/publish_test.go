@@ -0,0 +1,91 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPrivate(t *testing.T) {
+	marked := DefaultOptions()
+	marked.Publish = PublishMarked
+	drafts := DefaultOptions()
+	drafts.PublishDrafts = true
+	tests := []struct {
+		name    string
+		meta    map[string]interface{}
+		options *Options
+		private bool
+	}{
+		{"No frontmatter", map[string]interface{}{}, testOptions, false},
+		{"Private", map[string]interface{}{"private": true}, testOptions, true},
+		{"Not published", map[string]interface{}{"publish": false}, testOptions, true},
+		{"Draft", map[string]interface{}{"draft": true}, testOptions, true},
+		{"Published draft", map[string]interface{}{"draft": true}, drafts, false},
+		{"Private tag", map[string]interface{}{"tags": []interface{}{"work", "private"}}, testOptions, true},
+		{"Private tag wins", map[string]interface{}{"publish": true, "tags": "private"}, marked, true},
+		{"Unmarked", map[string]interface{}{}, marked, true},
+		{"Marked", map[string]interface{}{"publish": true}, marked, false},
+		{"Public tag", map[string]interface{}{"tags": []interface{}{"public"}}, marked, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.private, isPrivate(tt.meta, tt.options))
+		})
+	}
+}
+
+func TestPrivateNotesAreNotPublished(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Home.md":    "Working on [[Phoenix]] with [[Secret]]\n",
+		"Phoenix.md": "The project\n",
+		"Secret.md":  "+++\nprivate = true\n+++\nThe [[Phoenix]] budget is [[Confidential Stuff]]\n",
+		"Draft.md":   "---\ndraft: true\n---\nAbout [[Phoenix]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+
+	require.Nil(ProcessBackLinks(testBuildOptions(sourceDir, destDir)))
+	for _, name := range []string{"Secret.md", "Draft.md", "Confidential Stuff.md"} {
+		_, err := os.Stat(filepath.Join(destDir, name))
+		require.True(os.IsNotExist(err), "%s should not be written", name)
+	}
+	home, err := ioutil.ReadFile(filepath.Join(destDir, "Home.md"))
+	require.Nil(err)
+	require.Contains(string(home), "Working on [Phoenix](../phoenix/) with Secret\n")
+	phoenix, err := ioutil.ReadFile(filepath.Join(destDir, "Phoenix.md"))
+	require.Nil(err)
+	require.Contains(string(phoenix), "[Home](../home/)")
+	require.NotContains(string(phoenix), "budget")
+	require.NotContains(string(phoenix), "About")
+	require.NotContains(string(phoenix), "../secret/")
+}
+
+func TestHashtagsDoNotChangeThePolicy(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Draft.md":   "Not ready for #public yet\n",
+		"Public.md":  "---\ntags: [public]\n---\nWhy a #private beta?\n",
+		"Private.md": "---\ntags: [private]\n---\nSecret\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+	options.Publish = PublishMarked
+	options.Hashtags = HashtagsTaxonomy
+
+	require.Nil(ProcessBackLinks(options))
+	_, err := os.Stat(filepath.Join(destDir, "Draft.md"))
+	require.True(os.IsNotExist(err), "A #public tag in the text doesn't publish a note")
+	_, err = os.Stat(filepath.Join(destDir, "Private.md"))
+	require.True(os.IsNotExist(err))
+	public, err := ioutil.ReadFile(filepath.Join(destDir, "Public.md"))
+	require.Nil(err, "A #private tag in the text doesn't unpublish a note")
+	require.Contains(string(public), "tags = [\"public\", \"private\"]\n")
+}
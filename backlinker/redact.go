@@ -0,0 +1,102 @@
+package backlinker
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// These mark the regions of a note which are never published. A region can be fenced
+// with privateFence lines or with a pair of HTML comments, and a list item with the
+// privateTag is dropped along with everything nested under it.
+const (
+	privateFence = "%%private%%"
+	privateTag   = "#private"
+)
+
+var (
+	privateCommentStart = regexp.MustCompile(`^<!--\s*private\s*-->$`)
+	privateCommentEnd   = regexp.MustCompile(`^<!--\s*/private\s*-->$`)
+	listItem            = regexp.MustCompile(`^\s*([-*+]|\d+[.)])(\s|$)`)
+	privateTagged       = regexp.MustCompile(`(^|\s)` + privateTag + `($|[\s.,;:!?)])`)
+)
+
+// redactPrivate removes the private regions from the body of a note. This is done before
+// the body is parsed, so the links in those regions aren't backlinks and none of their
+// text can show up as the context of a backlink on another page.
+// Markers inside of fenced code blocks are left alone. A region without an end goes to the
+// end of the note, since it is better to publish too little than too much.
+// The second result has the line number in the original body of each line that is left.
+func redactPrivate(body []byte) ([]byte, []int) {
+	lines := strings.SplitAfter(string(body), "\n")
+	var result bytes.Buffer
+	kept := make([]int, 0, len(lines))
+	codeFence := ""
+	endMarker := func(string) bool { return false }
+	inRegion := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case inRegion:
+			if endMarker(trimmed) {
+				inRegion = false
+			}
+			continue
+		case codeFence != "":
+			if strings.HasPrefix(trimmed, codeFence) {
+				codeFence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			codeFence = trimmed[:3]
+		case trimmed == privateFence:
+			inRegion = true
+			endMarker = func(trimmed string) bool { return trimmed == privateFence }
+			continue
+		case privateCommentStart.MatchString(trimmed):
+			inRegion = true
+			endMarker = privateCommentEnd.MatchString
+			continue
+		case listItem.MatchString(line) && privateTagged.MatchString(line):
+			i = endOfListItem(lines, i)
+			continue
+		}
+		result.WriteString(line)
+		kept = append(kept, i)
+	}
+	return result.Bytes(), kept
+}
+
+// endOfListItem finds the last line of the list item that starts at lines[start], which
+// includes everything indented under it (with any blank lines in between).
+func endOfListItem(lines []string, start int) int {
+	indent := indentation(lines[start])
+	end := start
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if indentation(lines[i]) <= indent {
+			break
+		}
+		end = i
+	}
+	return end
+}
+
+// indentation is the width of the whitespace at the start of the line, with tabs
+// counted as four spaces the way CommonMark does.
+func indentation(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width
+		}
+	}
+	return width
+}
@@ -0,0 +1,72 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactPrivate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"Fence", "Before\n%%private%%\nSecret\n%%private%%\nAfter\n", "Before\nAfter\n"},
+		{"Comments", "Before\n<!-- private -->\nSecret\n<!--/private-->\nAfter\n", "Before\nAfter\n"},
+		{"Unterminated", "Before\n%%private%%\nSecret\n", "Before\n"},
+		{"Bullet", "* One\n* Two #private\n    * Nested\n\n      More\n* Three\n", "* One\n* Three\n"},
+		{"Numbered", "1. One #private.\n2. Two\n", "2. Two\n"},
+		{"Not a tag", "* About #privateer ships\n", "* About #privateer ships\n"},
+		{"Not a bullet", "Paragraph #private\n", "Paragraph #private\n"},
+		{"Code", "```\n%%private%%\n* x #private\n```\nAfter\n", "```\n%%private%%\n* x #private\n```\nAfter\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redacted, _ := redactPrivate([]byte(tt.input))
+			require.Equal(t, tt.want, string(redacted))
+		})
+	}
+}
+
+func TestRedactedLinksAreNotBacklinks(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Meeting.md": "+++\ntitle = \"Meeting\"\n+++\nTalked about [[Phoenix]]\n" +
+			"%%private%%\nThe [[Phoenix]] budget was cut\n%%private%%\n" +
+			"* Hiring for [[Phoenix]] #private\n  * Candidate [[Someone]]\n" +
+			"* Next steps for [[Phoenix]]\n",
+		"Phoenix.md": "The project\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+
+	require.Nil(ProcessBackLinks(options))
+	meeting, err := ioutil.ReadFile(filepath.Join(destDir, "Meeting.md"))
+	require.Nil(err)
+	require.Contains(string(meeting), "Talked about [Phoenix](../phoenix/)\n* Next steps for [Phoenix](../phoenix/)\n")
+	require.NotContains(string(meeting), "budget")
+	require.NotContains(string(meeting), "Hiring")
+	phoenix, err := ioutil.ReadFile(filepath.Join(destDir, "Phoenix.md"))
+	require.Nil(err)
+	require.NotContains(string(phoenix), "budget")
+	require.NotContains(string(phoenix), "Hiring")
+	_, err = os.Stat(filepath.Join(destDir, "Someone.md"))
+	require.True(os.IsNotExist(err), "Links in private regions don't create pages")
+
+	// Line numbers still refer to the source file
+	options.CheckOrphans = SeverityIgnore
+	report, err := Check(options)
+	require.Nil(err)
+	require.Empty(report.Problems)
+	file := createMarkdownFile("Meeting.md", false, testOptions)
+	file.bodyLine = 3
+	parseBody(file, []byte("A\n%%private%%\n[[Hidden]]\n%%private%%\n[[Shown]]\n"))
+	require.Len(file.links, 1)
+	require.Equal(8, file.links[0].Line)
+}
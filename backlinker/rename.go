@@ -0,0 +1,619 @@
+package backlinker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RenameEdit is a change that renaming a page makes to one of the notes in the content
+// directory.
+type RenameEdit struct {
+	// OldName and NewName are the slash-separated paths of the note relative to the
+	// content directory. They're only different for the page being renamed.
+	OldName string
+	NewName string
+	Before  []byte
+	After   []byte
+}
+
+// Rename renames the page oldName to newName in the content directory. Every link to the
+// page is changed to use the new name, keeping the alias and fragment of the link, and
+// the case it was written in ([[old name]] becomes [[new name]]). The file is renamed,
+// and with addAlias the old name is added to the aliases in its frontmatter.
+// With dryRun, nothing is changed, but the edits that would be made are still returned.
+func Rename(options *Options, oldName string, newName string, addAlias bool,
+	dryRun bool) ([]RenameEdit, error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, fmt.Errorf("%q is not a valid page name", newName)
+	}
+	err := options.validateReading()
+	if err != nil {
+		return nil, err
+	}
+	// Links in every note are renamed, so the private notes are kept
+	pages, _, err := readNotes(options)
+	if err != nil {
+		return nil, err
+	}
+	fileMap := pages.files
+
+	target, others := pages.lookup(wikilink{Target: oldName}, "")
+	if target == nil {
+		return nil, fmt.Errorf("page %s does not exist", oldName)
+	}
+	if len(others) > 0 {
+		return nil, fmt.Errorf("page %s could be %s, so it needs its folder", oldName,
+			fileNames(append([]*markdownFile{target}, others...)))
+	}
+	// The folders (in a name like projects/Old Name) are only needed to find the page
+	oldName = path.Base(oldName)
+	newLink := wikilink{Target: newName}
+	if other, exists := findPage(pages, newLink, target.OriginalName); exists && other != target {
+		return nil, fmt.Errorf("page %s already exists (%s)", newName, other.OriginalName)
+	}
+	newFilename := path.Join(path.Dir(target.OriginalName), sanitizePageName(newName)+".md")
+
+	// The backlinks find the notes that link to the page. Links in the private parts of
+	// notes aren't backlinks, so notes which have the old name anywhere are checked too.
+	linking := map[*markdownFile]bool{target: true}
+	for _, bl := range target.BackLinks {
+		linking[bl.OtherFile] = true
+	}
+	oldText := []byte(strings.ToLower(oldName))
+
+	edits := make([]RenameEdit, 0)
+	for _, file := range sourceFiles(fileMap) {
+		filetext, err := ioutil.ReadFile(filepath.Join(options.Content, filepath.FromSlash(file.OriginalName)))
+		if err != nil {
+			return nil, err
+		}
+		if !linking[file] && !bytes.Contains(bytes.ToLower(filetext), oldText) {
+			continue
+		}
+		edit := RenameEdit{
+			OldName: file.OriginalName,
+			NewName: file.OriginalName,
+			Before:  filetext,
+		}
+		edit.After, err = renameLinks(file, filetext, pages, target, oldName, newName, options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.OriginalName, err)
+		}
+		if file == target {
+			edit.NewName = newFilename
+			edit.After, err = renameFrontmatter(file, edit.After, oldName, newName, addAlias)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file.OriginalName, err)
+			}
+		}
+		if edit.OldName != edit.NewName || !bytes.Equal(edit.Before, edit.After) {
+			edits = append(edits, edit)
+		}
+	}
+
+	newPath := filepath.Join(options.Content, filepath.FromSlash(newFilename))
+	if _, err := os.Stat(newPath); err == nil && !strings.EqualFold(newFilename, target.OriginalName) {
+		return nil, fmt.Errorf("%s already exists", newFilename)
+	}
+	if dryRun {
+		return edits, nil
+	}
+	err = applyRenameEdits(options.Content, edits)
+	if err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
+// applyRenameEdits makes the edits in the content directory. The new text of every note
+// is written to a temporary file next to it first, and only once all of them have been
+// written are they moved into place. That way a note that can't be written (or a full
+// disk) leaves every note as it was, rather than leaving some links renamed.
+func applyRenameEdits(content string, edits []RenameEdit) error {
+	temps := make([]string, len(edits))
+	removeTemps := func() {
+		for _, temp := range temps {
+			if temp != "" {
+				os.Remove(temp)
+			}
+		}
+	}
+	for i, edit := range edits {
+		filename := filepath.Join(content, filepath.FromSlash(edit.OldName))
+		if bytes.Equal(edit.Before, edit.After) {
+			continue
+		}
+		temp, err := writeTempFile(filename, edit.After)
+		if err != nil {
+			removeTemps()
+			return err
+		}
+		temps[i] = temp
+	}
+
+	for i, edit := range edits {
+		filename := filepath.Join(content, filepath.FromSlash(edit.OldName))
+		newFilename := filepath.Join(content, filepath.FromSlash(edit.NewName))
+		var err error
+		if temps[i] != "" {
+			err = os.Rename(temps[i], filename)
+			temps[i] = ""
+		}
+		if err == nil && newFilename != filename {
+			err = os.Rename(filename, newFilename)
+		}
+		if err != nil {
+			removeTemps()
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTempFile writes the text to a new temporary file in the same folder as the file,
+// with the same permissions, and returns its name.
+func writeTempFile(filename string, text []byte) (string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(filename), ".sharedbrain-rename-")
+	if err != nil {
+		return "", err
+	}
+	_, err = temp.Write(text)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), info.Mode())
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", err
+	}
+	return temp.Name(), nil
+}
+
+// bodyOffset is the offset in the file text where the body starts, given the number of
+// lines that the frontmatter takes up.
+func bodyOffset(filetext []byte, bodyLine int) int {
+	offset := 0
+	for i := 0; i < bodyLine; i++ {
+		newline := bytes.IndexByte(filetext[offset:], '\n')
+		if newline == -1 {
+			return len(filetext)
+		}
+		offset += newline + 1
+	}
+	return offset
+}
+
+// renameLinks changes the links to the target page in the file text. The whole body is
+// parsed again, including the private parts that are left out of the build.
+func renameLinks(file *markdownFile, filetext []byte, pages *pageIndex, target *markdownFile,
+	oldName string, newName string, options *Options) ([]byte, error) {
+	offset := bodyOffset(filetext, file.bodyLine)
+	body := filetext[offset:]
+	var result bytes.Buffer
+	result.Write(filetext[:offset])
+	last := 0
+	for _, span := range findWikilinks(parseMarkdown(body), body) {
+		if span.BlockRef || span.Block != "" || span.Link.Target == "" {
+			continue
+		}
+		if span.Hashtag && !options.tagPages() && !options.hashtagAsWikilink(span) {
+			continue
+		}
+		// Links that use an alias of the page still work, so they're left alone
+		if linked, _ := findFile(pages, span.Link, file.OriginalName); linked != target {
+			continue
+		}
+		result.Write(body[last:span.Start])
+		result.WriteString(renamedSpan(body[span.Start:span.Stop], span, oldName, newName))
+		last = span.Stop
+	}
+	result.Write(body[last:])
+	return result.Bytes(), nil
+}
+
+// renamedSpan is the text of a link (or #tag) with the new name of the page in place of
+// the old one.
+func renamedSpan(raw []byte, span wikilinkSpan, oldName string, newName string) string {
+	if span.Hashtag {
+		tag := renamedTarget(span.Text, oldName, newName)
+		if bytes.HasPrefix(raw, []byte("#[[")) || strings.IndexFunc(tag, func(r rune) bool {
+			return !isTagRune(r)
+		}) != -1 {
+			return "#[[" + tag + "]]"
+		}
+		return "#" + tag
+	}
+	// Everything between the brackets is the span's text, and the target is at its start,
+	// before any #fragment or |alias
+	textStart := len(raw) - len("]]") - len(span.Text)
+	end := strings.IndexAny(span.Text, "#|")
+	if end == -1 {
+		end = len(span.Text)
+	}
+	written := strings.TrimSpace(span.Text[:end])
+	targetStart := textStart + strings.Index(span.Text, written)
+	return string(raw[:targetStart]) + renamedTarget(written, oldName, newName) +
+		string(raw[targetStart+len(written):])
+}
+
+// renamedTarget is the target of a link with the new name of the page. The folders in a
+// link like [[projects/Old Name]] are kept, since the page stays in its folder.
+func renamedTarget(written string, oldName string, newName string) string {
+	folders := strings.LastIndex(written, "/") + 1
+	return written[:folders] + matchCase(written[folders:], oldName, newName)
+}
+
+// matchCase writes the new name in the same style that the old name was written in:
+// all lower case, all upper case or as it was given.
+func matchCase(written string, oldName string, newName string) string {
+	switch {
+	case written == oldName:
+		return newName
+	case written == strings.ToLower(written):
+		return strings.ToLower(newName)
+	case written == strings.ToUpper(written):
+		return strings.ToUpper(newName)
+	}
+	return newName
+}
+
+// renameFrontmatter updates the frontmatter of the page being renamed: a title that is
+// the old name is changed to the new one, and with addAlias the old name becomes an
+// alias. This edits the user's own note, so only the lines with the title and aliases
+// are changed, and everything else (comments, the order of the keys and the way values
+// are written) is left as it is. Frontmatter that can't be changed that way is an error,
+// rather than being decoded and written out again.
+func renameFrontmatter(file *markdownFile, filetext []byte, oldName string, newName string,
+	addAlias bool) ([]byte, error) {
+	title, hasTitle := file.metadata["title"].(string)
+	changeTitle := hasTitle && title == oldName
+	aliases := metadataStrings(file.metadata, "aliases")
+	known := false
+	for _, alias := range aliases {
+		known = known || strings.EqualFold(alias, oldName)
+	}
+	changeAliases := addAlias && !known
+	if !changeTitle && !changeAliases {
+		return filetext, nil
+	}
+
+	offset := bodyOffset(filetext, file.bodyLine)
+	if file.frontmatterFormat == "" {
+		// Without any frontmatter there's no title, and nothing to lose
+		frontmatter, err := formatFrontmatter(FrontmatterTOML, map[string]interface{}{
+			"aliases": []string{oldName},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return append(frontmatter, filetext...), nil
+	}
+	front := frontmatterLines{
+		format: file.frontmatterFormat,
+		lines:  strings.SplitAfter(string(filetext[:offset]), "\n"),
+	}
+	if front.lines[len(front.lines)-1] == "" {
+		front.lines = front.lines[:len(front.lines)-1]
+	}
+	if changeTitle {
+		err := front.setTitle(oldName, newName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if changeAliases {
+		err := front.addAlias(append(aliases, oldName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(strings.Join(front.lines, "")), filetext[offset:]...), nil
+}
+
+// frontmatterLines is the text of a note's frontmatter, including its fences, split into
+// lines that keep their line endings.
+type frontmatterLines struct {
+	format string
+	lines  []string
+}
+
+// These find the start of a key's line at the top level of the frontmatter, and the
+// tables which end the top level of TOML.
+var (
+	tomlKeyLine     = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*`)
+	tomlTableHeader = regexp.MustCompile(`^\[\[?\s*[A-Za-z0-9_.\-"' ]+\]\]?\s*(#.*)?$`)
+	yamlKeyLine     = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*:(\s+|$)`)
+	jsonKeyLine     = regexp.MustCompile(`^\s*"([^"\\]+)"\s*:\s*`)
+	yamlListItem    = regexp.MustCompile(`^\s*- `)
+)
+
+// errFrontmatterEdit is the error for frontmatter that can't be changed one line at a time.
+func errFrontmatterEdit(key string) error {
+	return fmt.Errorf("the %s in the frontmatter can't be changed without rewriting it, "+
+		"so it has to be changed by hand", key)
+}
+
+// findKey finds the line that holds the key at the top level of the frontmatter, along
+// with the offset in the line where its value starts. The line is -1 if there isn't one.
+func (front *frontmatterLines) findKey(key string) (int, int) {
+	depth := 0
+	for i, line := range front.lines {
+		lineDepth := depth
+		if front.format == FrontmatterJSON {
+			depth += jsonNesting(line)
+		}
+		if i == 0 || (i == len(front.lines)-1 && front.format != FrontmatterJSON) {
+			continue
+		}
+		var match []int
+		switch front.format {
+		case FrontmatterTOML:
+			if tomlTableHeader.MatchString(strings.TrimRight(line, "\r\n")) {
+				return -1, 0
+			}
+			match = tomlKeyLine.FindStringSubmatchIndex(line)
+		case FrontmatterYAML:
+			match = yamlKeyLine.FindStringSubmatchIndex(line)
+		case FrontmatterJSON:
+			if lineDepth == 1 {
+				match = jsonKeyLine.FindStringSubmatchIndex(line)
+			}
+		}
+		if match != nil && line[match[2]:match[3]] == key {
+			return i, match[1]
+		}
+	}
+	return -1, 0
+}
+
+// jsonNesting is the change in the depth of the JSON objects and arrays over the line.
+func jsonNesting(line string) int {
+	nesting := 0
+	inString := false
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case !inString && (r == '{' || r == '['):
+			nesting++
+		case !inString && (r == '}' || r == ']'):
+			nesting--
+		}
+	}
+	return nesting
+}
+
+// lineValue decodes the value of the key on a single line of the frontmatter. It reports
+// false if the value isn't all on that line.
+func (front *frontmatterLines) lineValue(key string, line string) (interface{}, bool) {
+	text := strings.TrimSpace(line)
+	if front.format == FrontmatterJSON {
+		text = "{" + strings.TrimSuffix(text, ",") + "}"
+	}
+	meta, err := parseFrontmatter(front.format, []byte(text))
+	if err != nil {
+		return nil, false
+	}
+	value, hasValue := meta[key]
+	return value, hasValue
+}
+
+// setValue writes a new value in place of the old one on the line, keeping the key as
+// it was written, along with any comment after the value and the comma that separates
+// JSON values.
+func (front *frontmatterLines) setValue(index int, valueStart int, key string, value interface{}) {
+	line := front.lines[index]
+	text := strings.TrimRight(line, "\r\n")
+	trailing := line[len(text):]
+	if front.format == FrontmatterJSON && strings.HasSuffix(strings.TrimSpace(text), ",") {
+		trailing = "," + trailing
+	} else if front.format != FrontmatterJSON {
+		// A comment starts at a # which the value ends before
+		old, _ := front.lineValue(key, text)
+		for i := valueStart; i < len(text); i++ {
+			if text[i] != '#' || (text[i-1] != ' ' && text[i-1] != '\t') {
+				continue
+			}
+			if cut, _ := front.lineValue(key, text[:i]); reflect.DeepEqual(cut, old) {
+				trailing = " " + strings.TrimLeft(text[i:], " ") + trailing
+				break
+			}
+		}
+	}
+	front.lines[index] = line[:valueStart] + front.inlineValue(value) + trailing
+}
+
+// inlineValue writes a string or list of strings for a single line of the frontmatter.
+// Strings in JSON are also valid in TOML, and lists in JSON are valid YAML.
+func (front *frontmatterLines) inlineValue(value interface{}) string {
+	if text, isString := value.(string); isString && front.format == FrontmatterYAML {
+		data, err := yaml.Marshal(text)
+		if err == nil && bytes.Count(data, []byte("\n")) == 1 {
+			return strings.TrimSuffix(string(data), "\n")
+		}
+	}
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	// Strings and lists of strings can always be encoded
+	_ = encoder.Encode(value)
+	return strings.Replace(strings.TrimSuffix(data.String(), "\n"), `","`, `", "`, -1)
+}
+
+// setTitle changes the title from the old name to the new one.
+func (front *frontmatterLines) setTitle(oldName string, newName string) error {
+	index, valueStart := front.findKey("title")
+	if index == -1 {
+		return errFrontmatterEdit("title")
+	}
+	if value, _ := front.lineValue("title", front.lines[index]); value != oldName {
+		return errFrontmatterEdit("title")
+	}
+	front.setValue(index, valueStart, "title", newName)
+	return nil
+}
+
+// addAlias changes the aliases to the list given, which has the new alias at the end.
+// A YAML list gets a new item, and lists on a single line are written again.
+func (front *frontmatterLines) addAlias(aliases []string) error {
+	alias := aliases[len(aliases)-1]
+	index, valueStart := front.findKey("aliases")
+	if index == -1 {
+		return front.insertAliases(aliases)
+	}
+	value, hasValue := front.lineValue("aliases", front.lines[index])
+	if hasValue {
+		switch value.(type) {
+		case string, []interface{}:
+			front.setValue(index, valueStart, "aliases", aliases)
+			return nil
+		}
+		return errFrontmatterEdit("aliases")
+	}
+	if front.format != FrontmatterYAML {
+		return errFrontmatterEdit("aliases")
+	}
+	// The items of a YAML list are on the lines after the key
+	last := index
+	prefix := "- "
+	for last+1 < len(front.lines)-1 && yamlListItem.MatchString(front.lines[last+1]) {
+		last++
+		item := front.lines[last]
+		prefix = item[:strings.Index(item, "- ")+2]
+	}
+	front.insert(last+1, prefix+front.inlineValue(alias))
+	return nil
+}
+
+// insertAliases adds the aliases to frontmatter that doesn't have any yet. They go at
+// the end of the top level, or at the start of a JSON object.
+func (front *frontmatterLines) insertAliases(aliases []string) error {
+	switch front.format {
+	case FrontmatterTOML:
+		position := len(front.lines) - 1
+		for i := 1; i < position; i++ {
+			if tomlTableHeader.MatchString(strings.TrimRight(front.lines[i], "\r\n")) {
+				position = i
+				break
+			}
+		}
+		front.insert(position, "aliases = "+front.inlineValue(aliases))
+	case FrontmatterYAML:
+		front.insert(len(front.lines)-1, "aliases:")
+		front.insert(len(front.lines)-1, "- "+front.inlineValue(aliases[len(aliases)-1]))
+	case FrontmatterJSON:
+		if strings.TrimSpace(front.lines[0]) != "{" || len(front.lines) < 2 {
+			return errFrontmatterEdit("aliases")
+		}
+		next := front.lines[1]
+		indent := next[:len(next)-len(strings.TrimLeft(next, " \t"))]
+		comma := ","
+		if strings.HasPrefix(strings.TrimSpace(next), "}") {
+			comma = ""
+		}
+		front.insert(1, indent+`"aliases": `+front.inlineValue(aliases)+comma)
+	}
+	return nil
+}
+
+// insert adds a line to the frontmatter before the line at index.
+func (front *frontmatterLines) insert(index int, line string) {
+	front.lines = append(front.lines, "")
+	copy(front.lines[index+1:], front.lines[index:])
+	front.lines[index] = line + "\n"
+}
+
+// Diff shows the edit as a unified diff (without any context around the changes).
+func (edit RenameEdit) Diff() string {
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n", edit.OldName, edit.NewName)
+	before := splitLines(edit.Before)
+	after := splitLines(edit.After)
+
+	// common[i][j] is the length of the longest common subsequence of before[i:] and
+	// after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		if i < len(before) && j < len(after) && before[i] == after[j] {
+			i++
+			j++
+			continue
+		}
+		// A hunk runs until the lines match up again
+		startBefore, startAfter := i, j
+		for i < len(before) || j < len(after) {
+			if i < len(before) && j < len(after) && before[i] == after[j] {
+				break
+			}
+			if j == len(after) || (i < len(before) && common[i+1][j] >= common[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(startBefore, i-startBefore),
+			hunkRange(startAfter, j-startAfter))
+		for _, line := range before[startBefore:i] {
+			diff.WriteString("-" + line + "\n")
+		}
+		for _, line := range after[startAfter:j] {
+			diff.WriteString("+" + line + "\n")
+		}
+	}
+	return diff.String()
+}
+
+// splitLines splits the text into lines, without their line endings.
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
+
+// hunkRange is the range of lines in a hunk header, starting at the 0-based line start.
+// Empty ranges are given as the line before them, the way diff does it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,147 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRename(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"projects/Old Name.md": "---\ntitle: Old Name\n---\nSee [[Old Name#Plans]]\n",
+		"Notes.md": "About [[old name]], [[OLD NAME|the project]] and ![[Old Name#^block]]\n" +
+			"Tagged #[[Old Name]], but `[[Old Name]]` is code\n",
+		"Secret.md": "Public\n\n%%private%%\nAlso [[Old Name]]\n%%private%%\n",
+		"Other.md":  "Nothing to see\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	options := testBuildOptions(sourceDir, "")
+
+	edits, err := Rename(options, "Old Name", "New Name", true, true)
+	require.Nil(err)
+	require.Len(edits, 3)
+	require.Equal("Notes.md", edits[0].OldName)
+	require.Equal("Notes.md", edits[0].NewName)
+	require.Equal("--- a/Notes.md\n+++ b/Notes.md\n@@ -1,2 +1,2 @@\n"+
+		"-About [[old name]], [[OLD NAME|the project]] and ![[Old Name#^block]]\n"+
+		"-Tagged #[[Old Name]], but `[[Old Name]]` is code\n"+
+		"+About [[new name]], [[NEW NAME|the project]] and ![[New Name#^block]]\n"+
+		"+Tagged #[[New Name]], but `[[Old Name]]` is code\n", edits[0].Diff())
+	require.Equal("Secret.md", edits[1].OldName)
+	require.Equal("projects/Old Name.md", edits[2].OldName)
+	require.Equal("projects/New Name.md", edits[2].NewName)
+	_, err = os.Stat(filepath.Join(sourceDir, "projects", "Old Name.md"))
+	require.Nil(err, "A dry run doesn't change anything")
+
+	_, err = Rename(options, "Old Name", "New Name", true, false)
+	require.Nil(err)
+	_, err = os.Stat(filepath.Join(sourceDir, "projects", "Old Name.md"))
+	require.True(os.IsNotExist(err))
+	renamed, err := ioutil.ReadFile(filepath.Join(sourceDir, "projects", "New Name.md"))
+	require.Nil(err)
+	require.Equal("---\ntitle: New Name\naliases:\n- Old Name\n---\nSee [[New Name#Plans]]\n", string(renamed))
+	secret, err := ioutil.ReadFile(filepath.Join(sourceDir, "Secret.md"))
+	require.Nil(err)
+	require.Equal("Public\n\n%%private%%\nAlso [[New Name]]\n%%private%%\n", string(secret))
+
+	_, err = Rename(options, "Missing", "Anything", false, false)
+	require.Equal("page Missing does not exist", err.Error())
+	_, err = Rename(options, "Notes", "Other", false, false)
+	require.Equal("page Other already exists (Other.md)", err.Error())
+	_, err = Rename(options, "Notes", "old name", false, false)
+	require.Equal("page old name already exists (projects/New Name.md)", err.Error())
+}
+
+func TestRenameKeepsFrontmatter(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+	}{
+		{"YAML",
+			"---\n# Comment\ntitle: Old Name # the title\ndate: 2020-04-26\nparams:\n  title: Old Name\n---\nBody\n",
+			"---\n# Comment\ntitle: New Name # the title\ndate: 2020-04-26\nparams:\n  title: Old Name\naliases:\n- Old Name\n---\nBody\n"},
+		{"YAML list",
+			"---\naliases:\n  - JS\ntitle: \"Old Name\"\nweight: 2\n---\n",
+			"---\naliases:\n  - JS\n  - Old Name\ntitle: New Name\nweight: 2\n---\n"},
+		{"YAML flow list",
+			"---\naliases: [JS] # a comment\n---\n",
+			"---\naliases: [\"JS\", \"Old Name\"] # a comment\n---\n"},
+		{"TOML",
+			"+++\n# Comment\ntitle = \"Old Name\"\ndate = 2020-04-26\n[params]\nx = 1\n+++\n",
+			"+++\n# Comment\ntitle = \"New Name\"\ndate = 2020-04-26\naliases = [\"Old Name\"]\n[params]\nx = 1\n+++\n"},
+		{"TOML list",
+			"+++\naliases = [\"JS#1\"] # a comment\n+++\n",
+			"+++\naliases = [\"JS#1\", \"Old Name\"] # a comment\n+++\n"},
+		{"JSON",
+			"{\n  \"title\": \"Old Name\",\n  \"params\": {\"title\": \"Old Name\"}\n}\n",
+			"{\n  \"aliases\": [\"Old Name\"],\n  \"title\": \"New Name\",\n  \"params\": {\"title\": \"Old Name\"}\n}\n"},
+		{"No frontmatter",
+			"Body\n",
+			"+++\naliases = [\"Old Name\"]\n+++\nBody\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			sourceDir := writeTestFiles(t, map[string]string{"Old Name.md": tt.before})
+			defer os.RemoveAll(sourceDir)
+			edits, err := Rename(testBuildOptions(sourceDir, ""), "Old Name", "New Name", true, true)
+			require.Nil(err)
+			require.Len(edits, 1)
+			require.Equal(tt.after, string(edits[0].After))
+		})
+	}
+}
+
+func TestRenameRefusesToRewriteFrontmatter(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Old Name.md": "+++\naliases = [\n  \"JS\",\n]\n+++\nBody\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	_, err := Rename(testBuildOptions(sourceDir, ""), "Old Name", "New Name", true, true)
+	require.NotNil(err)
+	require.Contains(err.Error(), "Old Name.md: the aliases in the frontmatter can't be changed")
+	edits, err := Rename(testBuildOptions(sourceDir, ""), "Old Name", "New Name", false, true)
+	require.Nil(err)
+	require.Len(edits, 1)
+	require.Equal(edits[0].Before, edits[0].After)
+}
+
+func TestRenameChangesNothingWhenANoteCantBeWritten(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Notes.md": "About [[Old Name]]\n",
+	})
+	defer os.RemoveAll(sourceDir)
+
+	err := applyRenameEdits(sourceDir, []RenameEdit{
+		{OldName: "Notes.md", NewName: "Notes.md", Before: []byte("About [[Old Name]]\n"),
+			After: []byte("About [[New Name]]\n")},
+		{OldName: "Missing.md", NewName: "Missing.md", Before: []byte("[[Old Name]]\n"),
+			After: []byte("[[New Name]]\n")},
+	})
+	require.NotNil(err)
+	notes, err := ioutil.ReadFile(filepath.Join(sourceDir, "Notes.md"))
+	require.Nil(err)
+	require.Equal("About [[Old Name]]\n", string(notes))
+	entries, err := ioutil.ReadDir(sourceDir)
+	require.Nil(err)
+	require.Len(entries, 1, "The temporary files are removed")
+}
+
+func TestDiff(t *testing.T) {
+	require := require.New(t)
+	edit := RenameEdit{
+		OldName: "a.md",
+		NewName: "b.md",
+		Before:  []byte("one\ntwo\nthree\n"),
+		After:   []byte("zero\none\nthree\nfour\n"),
+	}
+	require.Equal("--- a/a.md\n+++ b/b.md\n@@ -0,0 +1,1 @@\n+zero\n@@ -2,1 +2,0 @@\n-two\n"+
+		"@@ -3,0 +4,1 @@\n+four\n", edit.Diff())
+}
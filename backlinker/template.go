@@ -0,0 +1,108 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"text/template"
+	"time"
+)
+
+// defaultBacklinksTemplate produces the standard backlinks section: a bullet linking to
+// each page that links here, with the context of the link in a nested bullet. The
+// unlinked mentions get a section of their own, in the same format.
+const defaultBacklinksTemplate = `{{if .Backlinks}}
+{{.Heading}}
+
+{{range .Backlinks}}* [{{.Title}}]({{.URL}})
+    * {{.Context}}
+{{end}}{{end}}{{if .Mentions}}
+{{.MentionsHeading}}
+
+{{range .Mentions}}* [{{.Title}}]({{.URL}})
+    * {{.Context}}
+{{end}}{{end}}`
+
+// backlinksData is what the backlinks template has access to.
+type backlinksData struct {
+	// Heading is the configured backlinks heading.
+	Heading string
+	// Page is the page that the backlinks section is being added to.
+	Page pageData
+	// Backlinks are the links to this page, with the most recent first.
+	Backlinks []backlinkData
+	// MentionsHeading is the configured unlinked mentions heading.
+	MentionsHeading string
+	// Mentions are the unlinked mentions of this page, if they are turned on, with the
+	// most recent first.
+	Mentions []backlinkData
+}
+
+// pageData describes a page for templates.
+type pageData struct {
+	Title    string
+	URL      string
+	Date     time.Time
+	IsNew    bool
+	Metadata map[string]interface{}
+}
+
+// backlinkData describes a single backlink for templates.
+type backlinkData struct {
+	// Title, URL, Date and Metadata are all of the page the link comes from.
+	Title    string
+	URL      string
+	Date     time.Time
+	Metadata map[string]interface{}
+	// Context is the markdown surrounding the link, with its wikilinks converted.
+	Context string
+	// RawContext is the markdown surrounding the link, as it was written.
+	RawContext string
+	// Section is the heading that was linked to, if any.
+	Section string
+	// Kind is "link", "embed" if the other page embeds this one, "tag" if it uses
+	// this page as a #tag or "mention" for an unlinked mention.
+	Kind string
+	// Block is the id of the block that was linked to, if any.
+	Block string
+}
+
+// loadBacklinksTemplate parses the template from the file, or uses the default template
+// if there is no file.
+func loadBacklinksTemplate(filename string) (*template.Template, error) {
+	if filename == "" {
+		return template.New("backlinks").Parse(defaultBacklinksTemplate)
+	}
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filename).Parse(string(text))
+}
+
+// newPageData gathers the template data for a page, as seen from the page `from`.
+func newPageData(file *markdownFile, from string, pages *pageIndex, options *Options) pageData {
+	date, _ := metadataDate(file.metadata)
+	return pageData{
+		Title:    file.Title,
+		URL:      pageLink(from, file, pages, options),
+		Date:     date,
+		IsNew:    file.IsNew,
+		Metadata: file.metadata,
+	}
+}
+
+// newBacklinkData gathers the template data for a backlink that appears on the page `file`.
+func newBacklinkData(file *markdownFile, bl backlink, pages *pageIndex,
+	options *Options) backlinkData {
+	other := newPageData(bl.OtherFile, file.OriginalName, pages, options)
+	return backlinkData{
+		Title:      other.Title,
+		URL:        other.URL,
+		Date:       other.Date,
+		Metadata:   other.Metadata,
+		Context:    convertLinksInText(bl.Context, file.OriginalName, pages, options),
+		RawContext: bl.Context,
+		Section:    bl.Section,
+		Kind:       bl.Kind,
+		Block:      bl.Block,
+	}
+}
@@ -0,0 +1,128 @@
+package backlinker
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomBacklinksTemplate(t *testing.T) {
+	require := require.New(t)
+	dir := writeTestFiles(t, map[string]string{
+		"table.tmpl": `{{.Heading}} for {{.Page.Title}}
+| Page | Date | Context |
+{{range .Backlinks}}| [{{.Title}}]({{.URL}}) | {{.Date.Format "Jan 2"}} | {{.Context}} ({{.RawContext}}) {{.Metadata.mood}} |
+{{end}}`,
+		"shortcode.tmpl": `{{range .Backlinks}}{{"{{"}}< backlink title="{{.Title}}" section="{{.Section}}" >{{"}}"}}
+{{end}}`,
+		"broken.tmpl": `{{range .Backlinks}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	options := testBuildOptions("content", "dest")
+	options.BacklinksTemplate = filepath.Join(dir, "table.tmpl")
+	require.Nil(options.Validate())
+
+	timestamp, _ := time.Parse(time.RFC3339, "2020-04-25T19:00:00Z")
+	other := createMarkdownFile("2020-04-25.md", false, options)
+	other.metadata["date"] = timestamp
+	other.metadata["mood"] = "happy"
+	page := createMarkdownFile("Page.md", false, options)
+	page.BackLinks = append(page.BackLinks, backlink{
+		OtherFile: other,
+		Context:   "About [[Page#Details]]",
+		Section:   "Details",
+	})
+	fileMap := map[string]*markdownFile{"page.md": page, "2020-04-25.md": other}
+
+	writer := bytes.Buffer{}
+	require.Nil(addBacklinks(page, newPageIndex(fileMap), options, &writer))
+	require.Equal(`## Backlinks for Page
+| Page | Date | Context |
+| [2020-04-25](../2020-04-25/) | Apr 25 | About [Page#Details](../page/#details) (About [[Page#Details]]) happy |
+`, writer.String())
+
+	options.BacklinksTemplate = filepath.Join(dir, "shortcode.tmpl")
+	require.Nil(options.Validate())
+	writer.Reset()
+	require.Nil(addBacklinks(page, newPageIndex(fileMap), options, &writer))
+	require.Equal("{{< backlink title=\"2020-04-25\" section=\"Details\" >}}\n", writer.String())
+
+	options.BacklinksTemplate = filepath.Join(dir, "broken.tmpl")
+	require.NotNil(options.Validate())
+	options.BacklinksTemplate = filepath.Join(dir, "missing.tmpl")
+	require.NotNil(options.Validate())
+}
+
+func TestBacklinksInFrontmatter(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"2020-04-25.md": "Talked about [[Page#Details]] today\n",
+		"Notes.md":      "+++\ntitle = \"My Notes\"\n+++\nSee [[Page]]\n",
+		"Page.md":       "The page body\n",
+	})
+	defer os.RemoveAll(sourceDir)
+
+	for _, format := range []string{FrontmatterTOML, FrontmatterYAML, FrontmatterJSON} {
+		t.Run(format, func(t *testing.T) {
+			destDir := writeTestFiles(t, nil)
+			defer os.RemoveAll(destDir)
+			options := testBuildOptions(sourceDir, destDir)
+			options.BacklinksMode = BacklinksFrontmatter
+			options.FrontmatterFormat = format
+			require.Nil(ProcessBackLinks(options))
+
+			output, err := ioutil.ReadFile(filepath.Join(destDir, "Page.md"))
+			require.Nil(err)
+			require.NotContains(string(output), "## Backlinks")
+			require.True(strings.HasSuffix(string(output), "\nThe page body\n"), string(output))
+
+			file := createMarkdownFile("Page.md", false, options)
+			require.Nil(extractFrontmatter(file, bufio.NewScanner(bytes.NewReader(output))))
+			backlinks, isList := file.metadata["backlinks"].([]interface{})
+			require.True(isList, "backlinks should be a list: %#v", file.metadata["backlinks"])
+			require.Equal(2, len(backlinks))
+			first := backlinks[0].(map[string]interface{})
+			require.Equal("2020-04-25", first["title"])
+			require.Equal("../2020-04-25/", first["url"])
+			require.Equal("Talked about [Page#Details](../page/#details) today", first["context"])
+			require.Equal("Talked about [[Page#Details]] today", first["raw_context"])
+			require.Equal("Details", first["section"])
+			require.NotNil(first["date"])
+			second := backlinks[1].(map[string]interface{})
+			require.Equal("My Notes", second["title"])
+			require.Equal("../notes/", second["url"])
+			_, hasDate := second["date"]
+			require.False(hasDate)
+		})
+	}
+
+	t.Run("ref links", func(t *testing.T) {
+		destDir := writeTestFiles(t, nil)
+		defer os.RemoveAll(destDir)
+		options := testBuildOptions(sourceDir, destDir)
+		options.BacklinksMode = BacklinksFrontmatter
+		options.LinkStrategy = LinksRef
+		options.LinkSection = "notes"
+		require.Nil(ProcessBackLinks(options))
+
+		output, err := ioutil.ReadFile(filepath.Join(destDir, "Notes.md"))
+		require.Nil(err)
+		require.Contains(string(output), "See [Page]({{< ref \"/notes/Page.md\" >}})\n")
+		output, err = ioutil.ReadFile(filepath.Join(destDir, "Page.md"))
+		require.Nil(err)
+		require.NotContains(string(output), "{{<")
+		file := createMarkdownFile("Page.md", false, options)
+		require.Nil(extractFrontmatter(file, bufio.NewScanner(bytes.NewReader(output))))
+		first := file.metadata["backlinks"].([]interface{})[0].(map[string]interface{})
+		require.Equal("/notes/2020-04-25/", first["url"])
+		require.Equal("Talked about [Page#Details](/notes/page/#details) today", first["context"])
+	})
+}
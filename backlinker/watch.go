@@ -0,0 +1,100 @@
+package backlinker
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Watch builds the site and then keeps watching options.Content, rebuilding whenever
+// markdown files are added, changed, renamed or deleted. Bursts of changes (like an
+// editor saving several files) are gathered up until things have been quiet for
+// options.WatchDelay, and then there's a single rebuild. Build errors are logged rather
+// than returned, so that a half-written note doesn't stop the watching.
+// Watch returns when stop is closed.
+func Watch(options *Options, stop <-chan struct{}) error {
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+	delay, err := time.ParseDuration(options.WatchDelay)
+	if err != nil {
+		return err
+	}
+	rebuild := func() {
+		err := ProcessBackLinks(options)
+		if err != nil {
+			log.Printf("Error when processing: %v\n", err)
+			return
+		}
+		log.Print("Generation complete!\n")
+	}
+	rebuild()
+
+	changes := make(chan string)
+	watchErrors := make(chan error, 1)
+	go func() {
+		watchErrors <- watchSource(options.Content, options.Dest, changes, stop)
+	}()
+	log.Printf("Watching %s for changes\n", options.Content)
+	return watchLoop(changes, watchErrors, delay, stop, rebuild)
+}
+
+// watchLoop calls rebuild once there have been no changes for the length of delay.
+func watchLoop(changes <-chan string, watchErrors <-chan error, delay time.Duration,
+	stop <-chan struct{}, rebuild func()) error {
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-watchErrors:
+			return err
+		case name := <-changes:
+			log.Printf("%s changed\n", name)
+			pending = time.After(delay)
+		case <-pending:
+			pending = nil
+			rebuild()
+		}
+	}
+}
+
+// isWatchedFile reports whether a change to the file should cause a rebuild. Only the
+// markdown files matter, and files in hidden directories are ignored (just as they are
+// by getFileList).
+func isWatchedFile(sourceDir string, filename string) bool {
+	if path.Ext(filename) != ".md" {
+		return false
+	}
+	relative, err := filepath.Rel(sourceDir, filename)
+	if err != nil {
+		return false
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(relative), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// isWatchedDir reports whether a directory should be watched. Hidden directories are
+// skipped, as is the destination directory in case it's inside of the source directory
+// (otherwise each build would trigger another one).
+func isWatchedDir(sourceDir string, destDir string, dir string) bool {
+	if dir != sourceDir && strings.HasPrefix(filepath.Base(dir), ".") {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absDest, err := filepath.Abs(destDir)
+	if err != nil {
+		return false
+	}
+	return absDir != absDest
+}
@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package backlinker
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyEvents are the changes that inotify is asked to report.
+const inotifyEvents = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// watchSource uses inotify to watch every directory under sourceDir, sending the name
+// of each markdown file (or directory) that changes. It returns when stop is closed.
+func watchSource(sourceDir string, destDir string, changes chan<- string, stop <-chan struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking file uses the runtime poller, so closing it ends the Read below
+	inotify := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-stop
+		inotify.Close()
+	}()
+
+	dirs := make(map[int]string)
+	addWatches := func(root string) error {
+		return filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Directories can disappear while they're being walked
+				return nil
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			if !isWatchedDir(sourceDir, destDir, dir) {
+				return filepath.SkipDir
+			}
+			wd, err := syscall.InotifyAddWatch(fd, dir, inotifyEvents)
+			if err != nil {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			dirs[wd] = dir
+			return nil
+		})
+	}
+	err = addWatches(sourceDir)
+	if err != nil {
+		inotify.Close()
+		return err
+	}
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := inotify.Read(buf)
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return err
+			}
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			dir, known := dirs[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(dirs, int(event.Wd))
+				continue
+			}
+			if !known {
+				continue
+			}
+			name := filepath.Join(dir, string(trimNulls(nameBytes)))
+
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			if isDir {
+				if !isWatchedDir(sourceDir, destDir, name) {
+					continue
+				}
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					err = addWatches(name)
+					if err != nil {
+						return err
+					}
+				}
+			} else if !isWatchedFile(sourceDir, name) {
+				continue
+			}
+			select {
+			case changes <- name:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+// trimNulls removes the padding from the end of a name in an inotify event.
+func trimNulls(name []byte) []byte {
+	for len(name) > 0 && name[len(name)-1] == 0 {
+		name = name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,76 @@
+//go:build !linux
+// +build !linux
+
+package backlinker
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pollInterval is how often the source directory is checked for changes on platforms
+// without inotify support.
+const pollInterval = time.Second
+
+// fileState is what's compared to tell whether a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchSource polls sourceDir, sending the name of each markdown file that is added,
+// changed or deleted. It returns when stop is closed.
+func watchSource(sourceDir string, destDir string, changes chan<- string, stop <-chan struct{}) error {
+	previous := scanSource(sourceDir, destDir)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		current := scanSource(sourceDir, destDir)
+		changed := make([]string, 0)
+		for name, state := range current {
+			if previousState, exists := previous[name]; !exists || previousState != state {
+				changed = append(changed, name)
+			}
+		}
+		for name := range previous {
+			if _, exists := current[name]; !exists {
+				changed = append(changed, name)
+			}
+		}
+		previous = current
+		for _, name := range changed {
+			select {
+			case changes <- name:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+// scanSource finds the state of every markdown file in the source directory.
+func scanSource(sourceDir string, destDir string) map[string]fileState {
+	result := make(map[string]fileState)
+	filepath.Walk(sourceDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if !isWatchedDir(sourceDir, destDir, filename) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isWatchedFile(sourceDir, filename) {
+			result[filename] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return result
+}
@@ -0,0 +1,99 @@
+package backlinker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchLoopDebounces(t *testing.T) {
+	require := require.New(t)
+	changes := make(chan string)
+	stop := make(chan struct{})
+	rebuilds := make(chan bool, 10)
+	done := make(chan error)
+	go func() {
+		done <- watchLoop(changes, nil, 50*time.Millisecond, stop, func() {
+			rebuilds <- true
+		})
+	}()
+
+	for i := 0; i < 5; i++ {
+		changes <- "Note.md"
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case <-rebuilds:
+	case <-time.After(2 * time.Second):
+		require.Fail("There should have been a rebuild")
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(0, len(rebuilds), "A burst of changes should only rebuild once")
+
+	close(stop)
+	require.Nil(<-done)
+}
+
+func TestIsWatchedFile(t *testing.T) {
+	require := require.New(t)
+	require.True(isWatchedFile("/notes", "/notes/Page.md"))
+	require.True(isWatchedFile("/notes", "/notes/projects/Page.md"))
+	require.False(isWatchedFile("/notes", "/notes/Page.md.swp"))
+	require.False(isWatchedFile("/notes", "/notes/.obsidian/Page.md"))
+	require.False(isWatchedDir("/notes", "/notes/public", "/notes/public"))
+	require.False(isWatchedDir("/notes", "/site", "/notes/.git"))
+	require.True(isWatchedDir("/notes", "/site", "/notes/projects"))
+}
+
+// waitForFile waits for the file to exist and have contents matching want.
+func waitForFile(t *testing.T, filename string, want func(string) bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		contents, err := ioutil.ReadFile(filename)
+		if err == nil && want(string(contents)) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Fail(t, "Timed out waiting for "+filename)
+}
+
+func TestWatchRebuilds(t *testing.T) {
+	require := require.New(t)
+	sourceDir := writeTestFiles(t, map[string]string{
+		"Page.md": "A page\n",
+	})
+	defer os.RemoveAll(sourceDir)
+	destDir := writeTestFiles(t, nil)
+	defer os.RemoveAll(destDir)
+	options := testBuildOptions(sourceDir, destDir)
+	options.WatchDelay = "20ms"
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Watch(options, stop)
+	}()
+	waitForFile(t, filepath.Join(destDir, "Page.md"), func(string) bool { return true })
+
+	// New folders are watched too
+	require.Nil(os.MkdirAll(filepath.Join(sourceDir, "projects"), 0755))
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(ioutil.WriteFile(filepath.Join(sourceDir, "projects", "New.md"), []byte("See [[Page]]\n"), 0644))
+	waitForFile(t, filepath.Join(destDir, "Page.md"), func(contents string) bool {
+		return strings.Contains(contents, "* [New](../projects/new/)")
+	})
+
+	require.Nil(os.Rename(filepath.Join(sourceDir, "projects", "New.md"), filepath.Join(sourceDir, "Renamed.md")))
+	waitForFile(t, filepath.Join(destDir, "Page.md"), func(contents string) bool {
+		return strings.Contains(contents, "* [Renamed](../renamed/)")
+	})
+
+	close(stop)
+	require.Nil(<-done)
+}
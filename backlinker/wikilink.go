@@ -0,0 +1,265 @@
+package backlinker
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+
+	wikilinks "github.com/dangoor/goldmark-wikilinks"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// wikilinkAttribute marks the link nodes in the goldmark AST that came from wikilinks,
+// as opposed to standard markdown links. embedAttribute marks the ones that were
+// written as embeds, like ![[Page]].
+const (
+	wikilinkAttribute = "sharedbrain-wikilink"
+	embedAttribute    = "sharedbrain-embed"
+)
+
+// wikilink is the parsed form of the text between [[ and ]].
+type wikilink struct {
+	// Target is the name of the page being linked to.
+	Target string
+	// Fragment is the heading within the target page, as in [[Target#Section]].
+	Fragment string
+	// Display is the text shown for the link. It's the same as Target unless the
+	// link has an alias, as in [[Target|Display text]].
+	Display string
+}
+
+// parseWikilink splits the text of a wikilink into its parts.
+func parseWikilink(linkText string) wikilink {
+	target := linkText
+	display := ""
+	if pipe := strings.Index(linkText, "|"); pipe != -1 {
+		target = linkText[:pipe]
+		display = strings.TrimSpace(linkText[pipe+1:])
+	}
+	target = strings.TrimSpace(target)
+	if display == "" {
+		display = target
+	}
+	fragment := ""
+	if hash := strings.Index(target, "#"); hash != -1 {
+		fragment = strings.TrimSpace(target[hash+1:])
+		target = strings.TrimSpace(target[:hash])
+	}
+	return wikilink{
+		Target:   target,
+		Fragment: fragment,
+		Display:  display,
+	}
+}
+
+// anchor is the HTML id that Hugo gives to the heading named in the fragment. Obsidian
+// allows nested headings (Page#Heading#Subheading), in which case the last one is used.
+// Links to blocks ([[Page#^block-id]]) use the anchor that is added to the block.
+func (wl wikilink) anchor() string {
+	if wl.Fragment == "" {
+		return ""
+	}
+	if id := wl.block(); id != "" {
+		return blockAnchor(id)
+	}
+	return headingID(wl.Fragment[strings.LastIndex(wl.Fragment, "#")+1:])
+}
+
+// headingID follows Hugo's default ("github") rules for turning heading text into an id:
+// letters, digits and underscores are kept (lower cased), spaces and hyphens become
+// hyphens, and everything else is dropped.
+func headingID(heading string) string {
+	var id strings.Builder
+	for _, r := range strings.TrimSpace(heading) {
+		switch {
+		case r == '-' || unicode.IsSpace(r):
+			id.WriteRune('-')
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			id.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return id.String()
+}
+
+// mappingName is the key used to look up the target page in the fileMap, which is the
+// lower case name of the file that the link points to.
+func (wl wikilink) mappingName() string {
+	return strings.ToLower(wl.Target) + ".md"
+}
+
+// newPageName is the name of the file that is created when the link points to a page
+// that doesn't exist (see findOrCreatePage). Unlike the names of the notes, it's
+// sanitized, since the link text could be anything.
+func (wl wikilink) newPageName() string {
+	return sanitizePageName(wl.Target) + ".md"
+}
+
+// markingParser wraps the goldmark-wikilinks inline parser in order to mark the nodes
+// that it creates, so that they can be found in the AST later.
+type markingParser struct {
+	parser.InlineParser
+}
+
+// Parse delegates to the wikilinks parser and marks any link that it returns.
+func (mp markingParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	node := mp.InlineParser.Parse(parent, block, pc)
+	if node != nil {
+		node.SetAttributeString(wikilinkAttribute, true)
+	}
+	return node
+}
+
+// embedParser finds ![[Page]] embeds. They need their own parser, because goldmark
+// otherwise takes the ![ as the start of an image.
+type embedParser struct {
+	wikilinks parser.InlineParser
+}
+
+// Trigger fulfills the goldmark InlineParser interface.
+func (ep embedParser) Trigger() []byte {
+	return []byte{'!'}
+}
+
+// Parse skips the ! and lets the wikilinks parser do the rest, marking the link that
+// it returns as an embed.
+func (ep embedParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("![[")) {
+		return nil
+	}
+	lineNumber, position := block.Position()
+	block.Advance(1)
+	node := ep.wikilinks.Parse(parent, block, pc)
+	if node == nil {
+		block.SetPosition(lineNumber, position)
+		return nil
+	}
+	node.SetAttributeString(wikilinkAttribute, true)
+	node.SetAttributeString(embedAttribute, true)
+	return node
+}
+
+// parseMarkdown parses the markdown with goldmark. Both collecting backlinks and converting
+// links are driven by this parse (see findWikilinks), so they agree on what is a link
+// (wikilinks in code are not, for example).
+func parseMarkdown(source []byte) ast.Node {
+	wl := wikilinks.NewWikilinksParser().WithTracker(nil).WithNormalizer(backlinkCollector{})
+	md := goldmark.New(
+		goldmark.WithParserOptions(
+			parser.WithInlineParsers(
+				util.Prioritized(embedParser{wl}, 101),
+				util.Prioritized(markingParser{wl}, 102),
+				util.Prioritized(blockRefParser{}, 103),
+				util.Prioritized(hashtagParser{}, 104),
+			),
+		),
+	)
+	reader := text.NewReader(source)
+	return md.Parser().Parse(reader)
+}
+
+// wikilinkSpan is a wikilink found in the markdown source. Spans are kept in the build
+// cache, so that files which haven't changed don't need to be parsed again.
+type wikilinkSpan struct {
+	// Start is the offset of the opening [[ (or ![[ for an embed) and Stop is the offset
+	// just past the closing ]]
+	Start int
+	Stop  int
+	// Line is the line of the source file (including the frontmatter) with the link
+	Line int
+	// Text is everything between the brackets
+	Text string
+	// Context is the markdown of the block (paragraph, list item...) that holds the link
+	Context string
+	Link    wikilink
+	// Embed is true for ![[Page]], which shows the other page (or a section of it)
+	// instead of linking to it.
+	Embed bool
+	// BlockRef is true for ((block-id)), which refers to a block on whichever page has
+	// it (see resolveBlockRefs). Text is the id.
+	BlockRef bool
+	// Hashtag is true for #tag and #[[multi word tag]], which are links to the page for
+	// the tag. Text is the name of the tag.
+	Hashtag bool
+	// Block is set for the ^block-id at the end of a block, which isn't a link at all,
+	// but is replaced with an anchor for the links to the block. Text is the text of
+	// the block.
+	Block string
+}
+
+// findWikilinks returns all of the wikilinks in the AST created by parseMarkdown, in the
+// order in which they appear in the source. Block references and the markers at the end
+// of blocks are included as well.
+func findWikilinks(root ast.Node, source []byte) []wikilinkSpan {
+	spans := make([]wikilinkSpan, 0)
+	ast.Walk(root, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			// The marker comes after everything else in the block
+			if node.Kind() == ast.KindParagraph || node.Kind() == ast.KindTextBlock {
+				if span, isMarker := blockMarkerSpan(node, source); isMarker {
+					spans = append(spans, span)
+				}
+			}
+			return ast.WalkContinue, nil
+		}
+		if _, isHashtag := node.AttributeString(hashtagAttribute); isHashtag {
+			if tagText, isText := node.FirstChild().(*ast.Text); isText {
+				spans = append(spans, hashtagSpan(node, tagText, source))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		if _, isBlockRef := node.AttributeString(blockRefAttribute); isBlockRef {
+			if idText, isText := node.FirstChild().(*ast.Text); isText {
+				spans = append(spans, blockRefSpan(node, idText, source))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		if _, isWikilink := node.AttributeString(wikilinkAttribute); !isWikilink {
+			return ast.WalkContinue, nil
+		}
+		// The wikilinks parser makes a single text node out of everything between the brackets
+		linkText, isText := node.FirstChild().(*ast.Text)
+		if !isText {
+			return ast.WalkSkipChildren, nil
+		}
+		destText := string(linkText.Segment.Value(source))
+		_, isEmbed := node.AttributeString(embedAttribute)
+		start := linkText.Segment.Start - 2
+		if isEmbed {
+			start--
+		}
+		spans = append(spans, wikilinkSpan{
+			Start:   start,
+			Stop:    linkText.Segment.Stop + 2,
+			Text:    destText,
+			Context: blockContext(node, source),
+			Link:    parseWikilink(destText),
+			Embed:   isEmbed,
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return spans
+}
+
+// blockContext gathers the text of the block that contains the node, the same way that
+// goldmark-wikilinks does for its tracker.
+func blockContext(node ast.Node, source []byte) string {
+	block := node.Parent()
+	for block != nil && block.Type() != ast.TypeBlock {
+		block = block.Parent()
+	}
+	if block == nil {
+		return ""
+	}
+	var context strings.Builder
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		context.Write(segment.Value(source))
+	}
+	return context.String()
+}
@@ -0,0 +1,80 @@
+package backlinker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWikilink(t *testing.T) {
+	require := require.New(t)
+	require.Equal(wikilink{Target: "Page", Display: "Page"}, parseWikilink("Page"))
+	require.Equal(wikilink{Target: "Project Phoenix", Display: "the project"},
+		parseWikilink("Project Phoenix|the project"))
+	require.Equal(wikilink{Target: "Project Phoenix", Display: "the project"},
+		parseWikilink("Project Phoenix | the project"))
+	require.Equal(wikilink{Target: "Page", Display: "Page"}, parseWikilink("Page|"))
+	require.Equal("project phoenix.md", parseWikilink("Project Phoenix|the project").mappingName())
+}
+
+func TestAliasedLinks(t *testing.T) {
+	require := require.New(t)
+	fileMap := map[string]*markdownFile{
+		"notes.md":           createMarkdownFile("Notes.md", false, testOptions),
+		"project phoenix.md": createMarkdownFile("Project Phoenix.md", false, testOptions),
+	}
+	collectBacklinksForFile(newPageIndex(fileMap), fileMap["notes.md"],
+		[]byte("We discussed [[Project Phoenix|the project]] today.\n"), testOptions)
+	require.Equal(2, len(fileMap), "No page should be created for the alias")
+	phoenix := fileMap["project phoenix.md"]
+	require.Equal(1, len(phoenix.BackLinks))
+	require.Equal("Notes.md", phoenix.BackLinks[0].OtherFile.OriginalName)
+
+	result := convertLinksInText("We discussed [[Project Phoenix|the project]] today.", "Notes.md", newPageIndex(fileMap), testOptions)
+	require.Equal("We discussed [the project](../project-phoenix/) today.", result)
+	require.Equal(2, len(fileMap), "No page should be created for the alias")
+
+	result = convertLinksInText("See [[Unknown Page|this]].", "Notes.md", newPageIndex(fileMap), testOptions)
+	require.Equal("See [this](../unknown-page/).", result)
+	unknown, exists := fileMap["unknown page.md"]
+	require.True(exists, "The target of an aliased link should be created")
+	require.Equal("Unknown Page.md", unknown.OriginalName)
+}
+
+func TestParseWikilinkWithFragment(t *testing.T) {
+	require := require.New(t)
+	require.Equal(wikilink{Target: "Page", Fragment: "Section", Display: "Page#Section"},
+		parseWikilink("Page#Section"))
+	require.Equal(wikilink{Target: "Page", Fragment: "Section", Display: "that part"},
+		parseWikilink("Page#Section|that part"))
+	require.Equal(wikilink{Target: "", Fragment: "Local", Display: "#Local"}, parseWikilink("#Local"))
+	require.Equal("page.md", parseWikilink("Page#Section").mappingName())
+	require.Equal("subheading", parseWikilink("Page#Heading#Subheading").anchor())
+}
+
+func TestHeadingID(t *testing.T) {
+	require := require.New(t)
+	require.Equal("section", headingID("Section"))
+	require.Equal("a-longer-section", headingID("A longer section"))
+	require.Equal("whats-new-in-v12", headingID("What's new in v1.2?"))
+	require.Equal("snake_case---dashes", headingID(" snake_case - dashes "))
+	require.Equal("café-über", headingID("Café Über"))
+}
+
+func TestSectionLinks(t *testing.T) {
+	require := require.New(t)
+	fileMap := map[string]*markdownFile{
+		"notes.md": createMarkdownFile("Notes.md", false, testOptions),
+		"page.md":  createMarkdownFile("Page.md", false, testOptions),
+	}
+	text := "See [[Page#Big Section]] and [[#Local Heading]]."
+	collectBacklinksForFile(newPageIndex(fileMap), fileMap["notes.md"], []byte(text+"\n"), testOptions)
+	require.Equal(2, len(fileMap), "No page should be created for a section")
+	page := fileMap["page.md"]
+	require.Equal(1, len(page.BackLinks))
+	require.Equal("Big Section", page.BackLinks[0].Section)
+	require.Equal(0, len(fileMap["notes.md"].BackLinks), "Local links aren't backlinks")
+
+	result := convertLinksInText(text, "Notes.md", newPageIndex(fileMap), testOptions)
+	require.Equal("See [Page#Big Section](../page/#big-section) and [#Local Heading](#local-heading).", result)
+}
@@ -10,5 +10,5 @@ require (
 	github.com/naoina/toml v0.1.1
 	github.com/stretchr/testify v1.5.1
 	github.com/yuin/goldmark v1.1.25
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	gopkg.in/yaml.v2 v2.2.7
 )
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"sharedbrain/backlinker"
 )
 
@@ -12,22 +15,202 @@ type builder struct {
 	dist bool
 }
 
+// commandLine holds the flags which aren't build options.
+type commandLine struct {
+	configFile string
+	version    bool
+	watch      bool
+	dryRun     bool
+	addAlias   bool
+	format     string
+	// args are the arguments left after the flags, like the page names for rename.
+	args []string
+}
+
+// newFlagSet creates the command line flags, which set the fields of options directly.
+// The current values in options are the defaults, so flags override the config file.
+func newFlagSet(options *backlinker.Options, cmd *commandLine) *flag.FlagSet {
+	flags := flag.NewFlagSet("sharedbrain", flag.ExitOnError)
+	flags.StringVar(&cmd.configFile, "config", backlinker.ConfigFile,
+		"Configuration file (only required if it is not the default)")
+	flags.StringVar(&options.Content, "content", options.Content, "Source directory")
+	flags.StringVar(&options.Dest, "dest", options.Dest, "Destination directory")
+	flags.StringVar(&options.FrontmatterFormat, "frontmatter-format", options.FrontmatterFormat,
+		"Format of the generated frontmatter: toml, yaml, json or same (as the input)")
+	flags.StringVar(&options.BacklinksHeading, "backlinks-heading", options.BacklinksHeading,
+		"Markdown line that starts the backlinks section")
+	flags.StringVar(&options.DailyNotePattern, "daily-note-pattern", options.DailyNotePattern,
+		"Regular expression for the filenames of daily notes")
+	flags.StringVar(&options.DailyNoteTime, "daily-note-time", options.DailyNoteTime,
+		"Time of day (with time zone) given to daily notes")
+	flags.StringVar(&options.LinkFormat, "link-format", options.LinkFormat,
+		"Format of relative links between pages, where {path} is the path to the other page")
+	flags.StringVar(&options.LinkStrategy, "link-strategy", options.LinkStrategy,
+		"How links are written: relative, root, absolute, markdown (links to the .md files) or ref (Hugo shortcodes)")
+	flags.StringVar(&options.LinkSection, "link-section", options.LinkSection,
+		"Hugo section that the pages are in, for root and absolute links and refs")
+	flags.StringVar(&options.BaseURL, "base-url", options.BaseURL, "Start of absolute links")
+	flags.BoolVar(&options.UglyURLs, "ugly-urls", options.UglyURLs,
+		"End root and absolute links with .html, like Hugo's uglyURLs")
+	flags.StringVar(&options.BacklinksTemplate, "backlinks-template", options.BacklinksTemplate,
+		"Go text/template file for the backlinks section (default is a bulleted list)")
+	flags.StringVar(&options.BacklinksMode, "backlinks-mode", options.BacklinksMode,
+		"Where backlinks go: markdown (a section at the end of the page) or frontmatter")
+	flags.BoolVar(&options.Cache, "cache", options.Cache,
+		"Only parse the files that changed since the last build (use -cache=false to parse everything)")
+	flags.StringVar(&options.WatchDelay, "watch-delay", options.WatchDelay,
+		"How long to wait after changes settle down before rebuilding in watch mode")
+	flags.StringVar(&options.BlockAnchor, "block-anchor", options.BlockAnchor,
+		"Markdown added to blocks with a ^block-id, where {id} is the anchor that links point to")
+	flags.StringVar(&options.Hashtags, "hashtags", options.Hashtags,
+		"How #tags are used: none, pages (links to a page for the tag), taxonomy (frontmatter tags) or both")
+	flags.IntVar(&options.EmbedDepth, "embed-depth", options.EmbedDepth,
+		"How deeply embedded pages (![[Page]]) can embed other pages")
+	flags.StringVar(&options.Publish, "publish", options.Publish,
+		"Which notes are published: all (except private ones) or marked (only the ones marked public)")
+	flags.BoolVar(&options.PublishDrafts, "publish-drafts", options.PublishDrafts,
+		"Publish the notes marked as drafts")
+	flags.StringVar(&options.CheckBrokenLinks, "check-broken-links", options.CheckBrokenLinks,
+		"Severity of links to pages that don't exist for the check command: error, warning or ignore")
+	flags.StringVar(&options.CheckOrphans, "check-orphans", options.CheckOrphans,
+		"Severity of notes without any links for the check command: error, warning or ignore")
+	flags.StringVar(&options.CheckNearDuplicates, "check-near-duplicates", options.CheckNearDuplicates,
+		"Severity of nearly identical page names for the check command: error, warning or ignore")
+	flags.BoolVar(&options.UnlinkedMentions, "unlinked-mentions", options.UnlinkedMentions,
+		"Add a section with the places where other notes mention a page's title without linking to it")
+	flags.StringVar(&options.UnlinkedMentionsHeading, "unlinked-mentions-heading", options.UnlinkedMentionsHeading,
+		"Markdown line that starts the unlinked mentions section")
+	flags.StringVar(&options.GraphOutput, "graph-output", options.GraphOutput,
+		"File that each build writes the graph of the notes to (.json, .graphml or .dot)")
+	flags.StringVar(&options.LocalGraphs, "local-graphs", options.LocalGraphs,
+		"Directory that each build writes the neighborhood of each page in the graph to, as JSON")
+	flags.IntVar(&options.LocalGraphDepth, "local-graph-depth", options.LocalGraphDepth,
+		"How many links away from a page its neighborhood goes")
+	flags.IntVar(&options.LocalGraphMaxNodes, "local-graph-max-nodes", options.LocalGraphMaxNodes,
+		"Most pages in the neighborhood of a page")
+	flags.StringVar(&cmd.format, "format", backlinker.GraphJSON,
+		"Format of the graph command's output: json, graphml or dot")
+	flags.BoolVar(&cmd.watch, "watch", false, "Keep running and rebuild whenever the content changes")
+	flags.BoolVar(&cmd.dryRun, "dry-run", false, "Show what rename would change without changing anything")
+	flags.BoolVar(&cmd.addAlias, "add-alias", false, "Keep the old name as an alias of the page when renaming")
+	flags.BoolVar(&cmd.version, "v", false, "Prints version")
+	return flags
+}
+
+// loadOptions puts together the options from the defaults, the config file and the
+// command line, in increasing order of precedence.
+func loadOptions(args []string) (*backlinker.Options, *commandLine) {
+	cmd := &commandLine{}
+
+	// The first pass over the flags is just to find the config file
+	newFlagSet(backlinker.DefaultOptions(), cmd).Parse(args)
+	options := backlinker.DefaultOptions()
+	err := backlinker.LoadOptions(cmd.configFile, options)
+	if os.IsNotExist(err) && cmd.configFile == backlinker.ConfigFile {
+		err = nil
+	}
+	if err != nil {
+		log.Fatalf("Unable to load configuration: %v\n", err)
+	}
+
+	flags := newFlagSet(options, cmd)
+	flags.Parse(args)
+	cmd.args = flags.Args()
+	return options, cmd
+}
+
+// watch rebuilds whenever the content changes, until the process is interrupted.
+func watch(options *backlinker.Options) {
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+	err := backlinker.Watch(options, stop)
+	if err != nil {
+		log.Fatalf("Error when watching: %v\n", err)
+	}
+}
+
+// check reports the problems with the notes, and exits with an error status if any of
+// them are errors.
+func check(options *backlinker.Options) {
+	report, err := backlinker.Check(options)
+	if err != nil {
+		log.Fatalf("Error when checking: %v\n", err)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	log.Printf("Found %d problems\n", len(report.Problems))
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+// rename renames a page in the content directory and fixes the links to it. With
+// -dry-run, the changes are shown as a diff instead.
+func rename(options *backlinker.Options, cmd *commandLine) {
+	if len(cmd.args) != 2 {
+		log.Fatalf("Usage: sharedbrain rename [-dry-run] [-add-alias] \"Old Name\" \"New Name\"\n")
+	}
+	edits, err := backlinker.Rename(options, cmd.args[0], cmd.args[1], cmd.addAlias, cmd.dryRun)
+	if err != nil {
+		log.Fatalf("Error when renaming: %v\n", err)
+	}
+	if cmd.dryRun {
+		for _, edit := range edits {
+			fmt.Print(edit.Diff())
+		}
+		return
+	}
+	log.Printf("Changed %d files\n", len(edits))
+}
+
+// graph writes the graph of the notes to stdout.
+func graph(options *backlinker.Options, cmd *commandLine) {
+	err := backlinker.Graph(options, cmd.format, os.Stdout)
+	if err != nil {
+		log.Fatalf("Error when exporting the graph: %v\n", err)
+	}
+}
+
 func main() {
-	content := flag.String("content", "", "Source directory")
-	dest := flag.String("dest", "", "Destination directory")
-	version := flag.Bool("v", false, "Prints version")
-	flag.Parse()
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && (args[0] == "check" || args[0] == "rename" || args[0] == "graph") {
+		command = args[0]
+		args = args[1:]
+	}
+	options, cmd := loadOptions(args)
 
 	log.Printf("sharedbrain %s\n", VERSION)
-	if *version {
+	if cmd.version {
 		log.Print("(just printing version, at your request)\n")
 		return
 	}
 
-	if *dest == "" || *content == "" {
-		log.Fatal("Either dest or content have not been set. Cannot proceed.\n")
+	if command == "check" {
+		check(options)
+		return
+	}
+	if command == "rename" {
+		rename(options, cmd)
+		return
+	}
+	if command == "graph" {
+		graph(options, cmd)
+		return
+	}
+
+	if cmd.watch {
+		watch(options)
+		return
 	}
-	err := backlinker.ProcessBackLinks(*content, *dest)
+
+	err := backlinker.ProcessBackLinks(options)
 	if err != nil {
 		log.Fatalf("Error when processing: %v\n", err)
 	}